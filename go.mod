@@ -4,6 +4,13 @@ go 1.24.0
 
 toolchain go1.24.9
 
+require (
+	github.com/yuin/goldmark v1.7.13
+	golang.org/x/image v0.30.0
+	golang.org/x/text v0.28.0
+	modernc.org/sqlite v1.39.1
+)
+
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
@@ -16,5 +23,4 @@ require (
 	modernc.org/libc v1.66.10 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
-	modernc.org/sqlite v1.39.1 // indirect
 )
@@ -0,0 +1,355 @@
+package card
+
+import (
+	"errors"
+	"fmt"
+	"highlights-anki/internal/models"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type Theme struct {
+	Name       string
+	Background color.RGBA
+	Foreground color.RGBA
+	Accent     color.RGBA
+	Muted      color.RGBA
+}
+
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var Themes = map[string]Theme{
+	"light": {"light", rgb(0xff, 0xff, 0xff), rgb(0x1f, 0x29, 0x37), rgb(0x3b, 0x82, 0xf6), rgb(0x6b, 0x72, 0x80)},
+	"dark":  {"dark", rgb(0x11, 0x18, 0x27), rgb(0xf9, 0xfa, 0xfb), rgb(0x60, 0xa5, 0xfa), rgb(0x9c, 0xa3, 0xaf)},
+	"sepia": {"sepia", rgb(0xf4, 0xec, 0xd8), rgb(0x43, 0x30, 0x22), rgb(0xb4, 0x53, 0x09), rgb(0x8a, 0x6f, 0x55)},
+	"ocean": {"ocean", rgb(0x0c, 0x4a, 0x6e), rgb(0xf0, 0xf9, 0xff), rgb(0x38, 0xbd, 0xf8), rgb(0xba, 0xe6, 0xfd)},
+}
+
+var Sizes = map[string]Size{
+	"square": {"square", 1080, 1080},
+	"wide":   {"wide", 1200, 630},
+	"story":  {"story", 1080, 1920},
+}
+
+const (
+	DefaultTheme = "light"
+	DefaultSize  = "square"
+)
+
+// FallbackFontPaths are fonts tried, in order, for characters the Go fonts
+// do not have, such as Chinese, Japanese and Korean text. Paths that do not
+// exist are skipped. HIGHLIGHTS_CARD_FONTS, a list of paths separated like
+// PATH, is tried first.
+var FallbackFontPaths = []string{
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/truetype/droid/DroidSansFallbackFull.ttf",
+	"/usr/share/fonts/truetype/wqy/wqy-microhei.ttc",
+	"/System/Library/Fonts/PingFang.ttc",
+	"/Library/Fonts/Arial Unicode.ttf",
+}
+
+var (
+	fontsOnce     sync.Once
+	fontsErr      error
+	quoteFont     *opentype.Font
+	sourceFont    *opentype.Font
+	captionFont   *opentype.Font
+	fallbackFonts []*opentype.Font
+)
+
+func rgb(r, g, b uint8) color.RGBA {
+	return color.RGBA{r, g, b, 0xff}
+}
+
+func loadFonts() error {
+	fontsOnce.Do(func() {
+		if quoteFont, fontsErr = opentype.Parse(goitalic.TTF); fontsErr != nil {
+			return
+		}
+		if sourceFont, fontsErr = opentype.Parse(gobold.TTF); fontsErr != nil {
+			return
+		}
+		if captionFont, fontsErr = opentype.Parse(goregular.TTF); fontsErr != nil {
+			return
+		}
+		paths := append(filepath.SplitList(os.Getenv("HIGHLIGHTS_CARD_FONTS")), FallbackFontPaths...)
+		for _, path := range paths {
+			f, err := loadFontFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				log.Println("[card.go] Error loading fallback font:", path, err)
+				continue
+			}
+			fallbackFonts = append(fallbackFonts, f)
+		}
+	})
+	return fontsErr
+}
+
+// loadFontFile reads a TrueType or OpenType font, taking the first font of
+// a collection.
+func loadFontFile(path string) (*opentype.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := opentype.Parse(data); err == nil {
+		return f, nil
+	}
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	return collection.Font(0)
+}
+
+// newFace returns a face of f at size that falls back to fallbackFonts for
+// characters f does not have.
+func newFace(f *opentype.Font, size float64) (font.Face, error) {
+	options := &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull}
+	face, err := opentype.NewFace(f, options)
+	if err != nil || len(fallbackFonts) == 0 {
+		return face, err
+	}
+	faces := fallbackFace{face}
+	for _, fallback := range fallbackFonts {
+		face, err := opentype.NewFace(fallback, options)
+		if err != nil {
+			faces.Close()
+			return nil, err
+		}
+		faces = append(faces, face)
+	}
+	return faces, nil
+}
+
+// fallbackFace draws each character with the first of its faces that has
+// a glyph for it, or with the first face when none has.
+type fallbackFace []font.Face
+
+func (faces fallbackFace) pick(r rune) font.Face {
+	for _, face := range faces {
+		if _, ok := face.GlyphAdvance(r); ok {
+			return face
+		}
+	}
+	return faces[0]
+}
+
+func (faces fallbackFace) Close() error {
+	for _, face := range faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (faces fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return faces.pick(r).Glyph(dot, r)
+}
+
+func (faces fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return faces.pick(r).GlyphBounds(r)
+}
+
+func (faces fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return faces.pick(r).GlyphAdvance(r)
+}
+
+// Kern only applies between characters drawn with the same face.
+func (faces fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := faces.pick(r0)
+	if face != faces.pick(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (faces fallbackFace) Metrics() font.Metrics {
+	return faces[0].Metrics()
+}
+
+// Render draws the highlight onto a card of the given theme and size and
+// encodes it as PNG. The quote is shrunk until it fits inside the card.
+func Render(w io.Writer, highlight models.Highlight, theme Theme, size Size) error {
+	if err := loadFonts(); err != nil {
+		return fmt.Errorf("loading fonts: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size.Width, size.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+
+	padding := size.Width / 12
+	textWidth := size.Width - 2*padding
+
+	// Accent bar along the left edge
+	draw.Draw(img, image.Rect(0, 0, padding/4, size.Height), &image.Uniform{theme.Accent}, image.Point{}, draw.Src)
+
+	// Footer: source name and type
+	sourceSize := float64(size.Width) / 28
+	sourceFace, err := newFace(sourceFont, sourceSize)
+	if err != nil {
+		return err
+	}
+	defer sourceFace.Close()
+	captionFace, err := newFace(captionFont, sourceSize*0.75)
+	if err != nil {
+		return err
+	}
+	defer captionFace.Close()
+
+	captionY := size.Height - padding
+	sourceY := captionY - int(sourceSize*1.4)
+	sourceLine := truncate(sourceFace, "— "+highlight.Source, textWidth)
+	drawText(img, sourceFace, theme.Foreground, padding, sourceY, sourceLine)
+	if highlight.SourceType != "" {
+		drawText(img, captionFace, theme.Muted, padding, captionY, strings.ToUpper(highlight.SourceType))
+	}
+
+	// Opening quote mark
+	markSize := float64(size.Width) / 6
+	markFace, err := newFace(sourceFont, markSize)
+	if err != nil {
+		return err
+	}
+	defer markFace.Close()
+	markY := padding + int(markSize*0.7)
+	drawText(img, markFace, theme.Accent, padding, markY, "“")
+
+	// Quote body, shrinking the font until every line fits above the footer
+	top := markY + padding/3
+	bottom := sourceY - int(sourceSize*2)
+	quoteSize := float64(size.Width) / 16
+	var face font.Face
+	var lines []string
+	var lineHeight int
+	for {
+		face, err = newFace(quoteFont, quoteSize)
+		if err != nil {
+			return err
+		}
+		lineHeight = int(quoteSize * 1.35)
+		lines = wrap(face, highlight.Content, textWidth)
+		if len(lines)*lineHeight <= bottom-top || quoteSize <= 12 {
+			break
+		}
+		face.Close()
+		quoteSize *= 0.9
+	}
+	defer face.Close()
+
+	maxLines := (bottom - top) / lineHeight
+	if maxLines < 1 {
+		maxLines = 1
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] = truncate(face, lines[maxLines-1]+"…", textWidth)
+	}
+
+	y := top + (bottom-top-len(lines)*lineHeight)/2 + lineHeight
+	for _, line := range lines {
+		drawText(img, face, theme.Foreground, padding, y, line)
+		y += lineHeight
+	}
+
+	return png.Encode(w, img)
+}
+
+func drawText(img draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{c},
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// wrap breaks text into lines no wider than maxWidth pixels. Words that are
+// wider than a full line (long URLs, unspaced scripts) are broken by rune.
+func wrap(face font.Face, text string, maxWidth int) []string {
+	limit := fixed.I(maxWidth)
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate) <= limit {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			for font.MeasureString(face, word) > limit {
+				cut := fitRunes(face, word, limit)
+				lines = append(lines, word[:cut])
+				word = word[cut:]
+			}
+			line = word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// fitRunes returns the byte length of the longest prefix of s that fits in
+// limit, always consuming at least one rune.
+func fitRunes(face font.Face, s string, limit fixed.Int26_6) int {
+	end := 0
+	for i := range s {
+		if i > 0 && font.MeasureString(face, s[:i]) > limit {
+			break
+		}
+		end = i
+	}
+	if end == 0 {
+		_, n := utf8.DecodeRuneInString(s)
+		return n
+	}
+	return end
+}
+
+func truncate(face font.Face, text string, maxWidth int) string {
+	limit := fixed.I(maxWidth)
+	if font.MeasureString(face, text) <= limit {
+		return text
+	}
+	text = strings.TrimSuffix(text, "…")
+	for text != "" && font.MeasureString(face, text+"…") > limit {
+		_, n := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-n]
+	}
+	return text + "…"
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// CoversFolder holds uploaded source covers, next to backups/.
+const CoversFolder = "covers"
+
+// MaxCoverSize is the largest cover image accepted, in bytes.
+const MaxCoverSize = 5 << 20
+
+// coverExtensions are the accepted cover image types by their sniffed
+// content type.
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ErrCoverType is returned by SaveCover for data that is not a JPEG, PNG,
+// GIF or WebP image.
+var ErrCoverType = errors.New("cover must be a JPEG, PNG, GIF or WebP image")
+
+// SaveCover writes a cover image to CoversFolder and returns its file name.
+// Files are named by their content, so a new cover never shows a cached
+// copy of the old one.
+func SaveCover(data []byte) (string, error) {
+	ext, ok := coverExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrCoverType
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:8]) + ext
+
+	if err := os.MkdirAll(CoversFolder, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(CoversFolder, name), data, 0o644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
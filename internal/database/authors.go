@@ -0,0 +1,63 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"log"
+)
+
+// authorHighlightIDs selects the ids of highlights from sources by an
+// author. It takes the author id.
+const authorHighlightIDs = `
+	SELECT h.id FROM highlights h
+	JOIN source_authors sa ON sa.source_id = h.source_id
+	WHERE sa.author_id = ?`
+
+// authorNameHighlightIDs is authorHighlightIDs for an author name, in any
+// case.
+const authorNameHighlightIDs = `
+	SELECT h.id FROM highlights h
+	JOIN source_authors sa ON sa.source_id = h.source_id
+	JOIN authors a ON a.id = sa.author_id
+	WHERE a.name = ?`
+
+// authorCounts selects authors with the number of their sources that have
+// highlights and the number of those highlights.
+const authorCounts = `
+	SELECT a.id, a.name, COUNT(DISTINCT s.id), COUNT(h.id)
+	FROM authors a
+	JOIN source_authors sa ON sa.author_id = a.id
+	JOIN sources s ON s.id = sa.source_id
+	JOIN highlights h ON h.source_id = s.id`
+
+// GetAuthors returns every author of a source with highlights, by name.
+func (db *Db) GetAuthors() ([]models.Author, error) {
+	rows, err := db.Query(authorCounts + " GROUP BY a.id ORDER BY a.name COLLATE NOCASE")
+	if err != nil {
+		log.Println("[authors.go] Error querying authors:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var authors []models.Author
+	for rows.Next() {
+		var author models.Author
+		if err := rows.Scan(&author.ID, &author.Name, &author.Sources, &author.Highlights); err != nil {
+			log.Println("[authors.go] Error scanning author:", err)
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	return authors, rows.Err()
+}
+
+// GetAuthor returns the author with the given id. It returns sql.ErrNoRows
+// if there is none or none of their sources has highlights.
+func (db *Db) GetAuthor(id int) (models.Author, error) {
+	var author models.Author
+	err := db.QueryRow(authorCounts+" WHERE a.id = ? GROUP BY a.id", id).
+		Scan(&author.ID, &author.Name, &author.Sources, &author.Highlights)
+	if err != nil {
+		log.Println("[authors.go] Error querying author:", id, err)
+	}
+	return author, err
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestAuthorFilter(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits compound.", "Systems over goals.")
+	importTexts(t, db, "Clear Thinking", "Habits of mind matter.")
+	importTexts(t, db, "Walden", "Simplify, simplify.")
+	setAuthors := func(source string, authors ...string) {
+		t.Helper()
+		if err := db.SetSourceMetadata(models.Source{ID: sourceID(t, db, source)}, authors); err != nil {
+			t.Fatalf("SetSourceMetadata: %v", err)
+		}
+	}
+	setAuthors("Atomic Habits", "James Clear")
+	setAuthors("Clear Thinking", "Shane Parrish")
+	setAuthors("Walden", "Henry David Thoreau")
+
+	authors, err := db.GetAuthors()
+	if err != nil {
+		t.Fatalf("GetAuthors: %v", err)
+	}
+	var clear models.Author
+	for _, author := range authors {
+		if author.Name == "James Clear" {
+			clear = author
+		}
+	}
+	if clear.Sources != 1 || clear.Highlights != 2 {
+		t.Fatalf("James Clear = %+v, want 1 source with 2 highlights", clear)
+	}
+
+	filter := HighlightFilter{AuthorID: clear.ID}
+	highlights, err := db.GetRandomHighlights(10, filter)
+	if err != nil {
+		t.Fatalf("GetRandomHighlights: %v", err)
+	}
+	var got []string
+	for _, highlight := range highlights {
+		got = append(got, highlight.Content)
+	}
+	slices.Sort(got)
+	if want := []string{"Habits compound.", "Systems over goals."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("review of James Clear = %q, want %q", got, want)
+	}
+	sources, err := db.GetSources(filter)
+	if err != nil || len(sources) != 1 || sources[0].Name != "Atomic Habits" {
+		t.Errorf("GetSources = %+v, %v; want Atomic Habits", sources, err)
+	}
+
+	// author: matches the whole name in any case, not a word of it
+	for query, want := range map[string][]string{
+		`habits author:"james clear"`: {"Habits compound."},
+		"habits author:clear":         nil,
+		"author:thoreau":              nil,
+	} {
+		page, err := search.GetSearchResults(query, SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got []string
+		for _, result := range page.Results {
+			got = append(got, contentOf(t, db, result.ID))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+}
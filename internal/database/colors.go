@@ -0,0 +1,42 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// SetHighlightColor sets the color of a highlight; an empty color removes
+// it. It returns sql.ErrNoRows if there is no highlight with that id.
+func (db *Db) SetHighlightColor(id int, color string) error {
+	res, err := db.Exec("UPDATE highlights SET color = ? WHERE id = ?", nullString(color), id)
+	if err != nil {
+		log.Println("[colors.go] Error setting highlight color:", id, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetColorCounts returns how many highlights have each color, keyed by
+// color.
+func (db *Db) GetColorCounts() (map[string]int, error) {
+	rows, err := db.Query("SELECT color, COUNT(*) FROM highlights WHERE color IS NOT NULL GROUP BY color")
+	if err != nil {
+		log.Println("[colors.go] Error counting highlight colors:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var color string
+		var count int
+		if err := rows.Scan(&color, &count); err != nil {
+			return nil, err
+		}
+		counts[color] = count
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestColorFilter(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits compound.", "Systems over goals.", "Identity first.")
+	for content, color := range map[string]string{"Habits compound.": "yellow", "Systems over goals.": "yellow", "Identity first.": "blue"} {
+		if err := db.SetHighlightColor(findHighlight(t, db, "Atomic Habits", content).ID, color); err != nil {
+			t.Fatalf("SetHighlightColor: %v", err)
+		}
+	}
+	// An empty color removes it
+	if err := db.SetHighlightColor(findHighlight(t, db, "Atomic Habits", "Identity first.").ID, ""); err != nil {
+		t.Fatalf("SetHighlightColor: %v", err)
+	}
+	if err := db.SetHighlightColor(-1, "red"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetHighlightColor of a missing highlight = %v, want sql.ErrNoRows", err)
+	}
+
+	counts, err := db.GetColorCounts()
+	if err != nil {
+		t.Fatalf("GetColorCounts: %v", err)
+	}
+	if want := map[string]int{"yellow": 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("color counts = %v, want %v", counts, want)
+	}
+
+	highlights, err := db.GetRandomHighlights(10, HighlightFilter{Color: "yellow"})
+	if err != nil {
+		t.Fatalf("GetRandomHighlights: %v", err)
+	}
+	var got []string
+	for _, highlight := range highlights {
+		got = append(got, highlight.Content)
+	}
+	slices.Sort(got)
+	if want := []string{"Habits compound.", "Systems over goals."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("yellow highlights = %q, want %q", got, want)
+	}
+
+	for query, want := range map[string][]string{
+		"habits color:YELLOW": {"Habits compound."},
+		"identity color:blue": nil,
+		"identity kind:quote": {"Identity first."},
+	} {
+		page, err := search.GetSearchResults(query, SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got []string
+		for _, result := range page.Results {
+			got = append(got, contentOf(t, db, result.ID))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -1,15 +1,25 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"highlights-anki/internal/models"
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
 type Db struct {
 	*sql.DB
+
+	// sourceTypes are the stored source types, loaded once and after every
+	// change so templates can look them up without a query per highlight.
+	sourceTypesMu sync.RWMutex
+	sourceTypes   []models.SourceType
 }
 
 func InitDb(dbUri string) (*Db, error) {
@@ -26,12 +36,34 @@ func InitDb(dbUri string) (*Db, error) {
 		return nil, err
 	}
 
+	// Times are UTC in SQLite's own "YYYY-MM-DD HH:MM:SS" format, so they
+	// sort as text and work with date() and datetime().
 	createTableQuery := `
 	CREATE TABLE IF NOT EXISTS highlights (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_id INTEGER,
 		source TEXT,
 		source_type TEXT,
-		content TEXT
+		content TEXT,
+		raw_content TEXT,
+		content_hash TEXT,
+		created_at TEXT,
+		updated_at TEXT,
+		highlighted_at TEXT,
+		import_batch TEXT,
+		importer TEXT,
+		note TEXT,
+		episode_id INTEGER,
+		timestamp_seconds INTEGER,
+		chapter TEXT,
+		location INTEGER,
+		page INTEGER,
+		percent INTEGER,
+		color TEXT,
+		kind TEXT,
+		favorite INTEGER NOT NULL DEFAULT 0,
+		pinned INTEGER NOT NULL DEFAULT 0,
+		suspended INTEGER NOT NULL DEFAULT 0
 	);`
 
 	_, err = db.Exec(createTableQuery)
@@ -40,47 +72,300 @@ func InitDb(dbUri string) (*Db, error) {
 		return nil, err
 	}
 
-	return &Db{db}, nil
-}
-
-func (db *Db) InsertHighlights(highlights []models.Highlight) (count int, err error) {
-	tx, err := db.Begin()
+	if err := migrateColumns(db, "highlights", addedHighlightColumns); err != nil {
+		log.Println("[db.go] Error migrating highlights table:", err)
+		return nil, err
+	}
 
+	createHighlightIndexesQuery := `
+	DROP INDEX IF EXISTS highlights_source_hash;
+	CREATE INDEX IF NOT EXISTS highlights_source_id_hash ON highlights (source_id, content_hash);
+	CREATE INDEX IF NOT EXISTS highlights_created_at ON highlights (created_at);
+	CREATE INDEX IF NOT EXISTS highlights_import_batch ON highlights (import_batch);
+
+	-- Recreated so databases made before a column was added track it too.
+	DROP TRIGGER IF EXISTS highlights_touch;
+	CREATE TRIGGER highlights_touch AFTER UPDATE OF
+		source, source_type, content, note, episode_id, timestamp_seconds,
+		chapter, location, page, percent, color, kind, favorite, pinned, suspended
+	ON highlights BEGIN
+		UPDATE highlights SET updated_at = datetime('now') WHERE id = new.id;
+	END;`
+
+	_, err = db.Exec(createHighlightIndexesQuery)
 	if err != nil {
-		println("Error beginning transaction:", err)
-		return 0, err
+		log.Println("[db.go] Error creating highlights indexes:", err)
+		return nil, err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO highlights (source, source_type, content) VALUES (?, ?, ?)")
+	if err := backfillContentHashes(db); err != nil {
+		log.Println("[db.go] Error adding content hashes:", err)
+		return nil, err
+	}
+
+	// Pairs of highlights the user marked as not being duplicates of each
+	// other, with id_a < id_b.
+	createDismissalsQuery := `
+	CREATE TABLE IF NOT EXISTS duplicate_dismissals (
+		id_a INTEGER NOT NULL,
+		id_b INTEGER NOT NULL,
+		PRIMARY KEY (id_a, id_b)
+	);`
+
+	_, err = db.Exec(createDismissalsQuery)
 	if err != nil {
-		println("Error preparing statement:", err)
-		return 0, err
+		log.Println("[db.go] Error creating duplicate dismissals table:", err)
+		return nil, err
 	}
 
-	defer stmt.Close()
-	count = 0
+	if err := createImportBatchesTable(db); err != nil {
+		log.Println("[db.go] Error creating import batches table:", err)
+		return nil, err
+	}
 
-	for _, highlight := range highlights {
-		_, err := stmt.Exec(highlight.Source, highlight.SourceType, highlight.Content)
+	if err := createTagTables(db); err != nil {
+		log.Println("[db.go] Error creating tag tables:", err)
+		return nil, err
+	}
+
+	if err := createSourceTables(db); err != nil {
+		log.Println("[db.go] Error creating source tables:", err)
+		return nil, err
+	}
+
+	if err := backfillSourceIDs(db); err != nil {
+		log.Println("[db.go] Error linking highlights to sources:", err)
+		return nil, err
+	}
+
+	if err := createEpisodesTable(db); err != nil {
+		log.Println("[db.go] Error creating podcast episodes table:", err)
+		return nil, err
+	}
+
+	if err := createSourceTypesTable(db); err != nil {
+		log.Println("[db.go] Error creating source types table:", err)
+		return nil, err
+	}
+
+	library := &Db{DB: db}
+	if err := library.loadSourceTypes(); err != nil {
+		log.Println("[db.go] Error loading source types:", err)
+		return nil, err
+	}
+	return library, nil
+}
+
+// addedHighlightColumns are the columns of highlights that databases
+// created by older versions may lack, with their types. Highlights
+// imported before a column existed keep NULL there.
+var addedHighlightColumns = []string{
+	"content_hash TEXT", "created_at TEXT", "updated_at TEXT", "highlighted_at TEXT",
+	"import_batch TEXT", "importer TEXT", "note TEXT", "source_id INTEGER",
+	"episode_id INTEGER", "timestamp_seconds INTEGER",
+	"chapter TEXT", "location INTEGER", "page INTEGER", "percent INTEGER",
+	"raw_content TEXT", "color TEXT", "kind TEXT",
+	"favorite INTEGER NOT NULL DEFAULT 0", "pinned INTEGER NOT NULL DEFAULT 0",
+	"suspended INTEGER NOT NULL DEFAULT 0",
+}
+
+// migrateColumns adds the columns, given as "name TYPE", that table does
+// not have yet.
+func migrateColumns(db *sql.DB, table string, columns []string) error {
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		var found int
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, name).Scan(&found)
 		if err != nil {
-			println("Error inserting highlight:", err)
-			tx.Rollback()
-			return count, err
+			return err
+		}
+		if found > 0 {
+			continue
+		}
+		log.Println("[db.go] Adding column to "+table+":", name)
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillContentHashes hashes highlights stored before content hashes
+// existed.
+func backfillContentHashes(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, content FROM highlights WHERE content_hash IS NULL")
+	if err != nil {
+		return err
+	}
+	hashes := map[int]string{}
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
 		}
-		count++
+		hashes[id] = ContentHash(content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(hashes) == 0 {
+		return err
 	}
 
-	err = tx.Commit()
+	tx, err := db.Begin()
 	if err != nil {
-		println("Error committing transaction:", err)
-		return 0, err
+		return err
+	}
+	defer tx.Rollback()
+	for id, hash := range hashes {
+		if _, err := tx.Exec("UPDATE highlights SET content_hash = ? WHERE id = ?", hash, id); err != nil {
+			return err
+		}
 	}
+	log.Println("[db.go] Hashed existing highlights:", len(hashes))
+	return tx.Commit()
+}
 
-	return count, nil
+// ContentHash identifies a highlight's text regardless of case and
+// whitespace, so re-importing the same file finds the highlights it
+// already holds.
+func ContentHash(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
 }
 
-func (db *Db) GetRandomHighlights(limit int) ([]models.Highlight, error) {
-	rows, err := db.Query("SELECT source, source_type, content FROM highlights ORDER BY RANDOM() LIMIT ?", limit)
+// highlightColumns are the columns read by scanHighlight, in order.
+const highlightColumns = `id, source_id, source, source_type, content, raw_content,
+	created_at, updated_at, highlighted_at, import_batch, importer, note,
+	episode_id, timestamp_seconds, chapter, location, page, percent, color, kind,
+	favorite, pinned, suspended`
+
+// timeFormat is how times are stored: UTC in SQLite's datetime() format.
+const timeFormat = "2006-01-02 15:04:05"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanHighlight reads a highlight selected with highlightColumns.
+func scanHighlight(row rowScanner) (models.Highlight, error) {
+	var highlight models.Highlight
+	var rawContent, createdAt, updatedAt, highlightedAt, importBatch, importer, note, chapter, color, kind sql.NullString
+	var sourceID, episodeID, timestamp, location, page, percent sql.NullInt64
+	err := row.Scan(&highlight.ID, &sourceID, &highlight.Source, &highlight.SourceType, &highlight.Content, &rawContent,
+		&createdAt, &updatedAt, &highlightedAt, &importBatch, &importer, &note,
+		&episodeID, &timestamp, &chapter, &location, &page, &percent, &color, &kind,
+		&highlight.Favorite, &highlight.Pinned, &highlight.Suspended)
+	if err != nil {
+		return highlight, err
+	}
+	highlight.SourceID = int(sourceID.Int64)
+	highlight.RawContent = rawContent.String
+	highlight.CreatedAt = parseTime(createdAt)
+	highlight.UpdatedAt = parseTime(updatedAt)
+	highlight.HighlightedAt = parseTime(highlightedAt)
+	highlight.ImportBatch = importBatch.String
+	highlight.Importer = importer.String
+	highlight.Note = note.String
+	highlight.EpisodeID = int(episodeID.Int64)
+	highlight.Timestamp = int(timestamp.Int64)
+	highlight.Chapter = chapter.String
+	highlight.Location = int(location.Int64)
+	highlight.Page = int(page.Int64)
+	highlight.Percent = int(percent.Int64)
+	highlight.Color = color.String
+	highlight.Kind = kind.String
+	return highlight, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s sql.NullString) time.Time {
+	if !s.Valid {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(timeFormat, s.String, time.UTC)
+	if err != nil {
+		log.Println("[db.go] Error parsing stored time:", s.String, err)
+		return time.Time{}
+	}
+	return t
+}
+
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return formatTime(t)
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// HighlightFilter narrows the highlights a listing returns. Zero fields
+// match every highlight.
+type HighlightFilter struct {
+	// Tag matches highlights tagged with it directly or through their source.
+	Tag string
+	// AuthorID matches highlights from sources by that author.
+	AuthorID int
+	// Color and Kind match highlights of that color and kind.
+	Color string
+	Kind  string
+	// Favorite matches only favorite highlights.
+	Favorite bool
+}
+
+// where returns the condition on highlights.id for the filter, to follow
+// WHERE, and its arguments. It is "1" for the zero filter.
+func (f HighlightFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	if f.Tag != "" {
+		conds = append(conds, "id IN ("+taggedHighlightIDs+")")
+		args = append(args, f.Tag, f.Tag)
+	}
+	if f.AuthorID != 0 {
+		conds = append(conds, "id IN ("+authorHighlightIDs+")")
+		args = append(args, f.AuthorID)
+	}
+	if f.Color != "" {
+		conds = append(conds, "color = ?")
+		args = append(args, f.Color)
+	}
+	if f.Kind != "" {
+		conds = append(conds, "COALESCE(kind, 'quote') = ?")
+		args = append(args, f.Kind)
+	}
+	if f.Favorite {
+		conds = append(conds, "favorite = 1")
+	}
+	if len(conds) == 0 {
+		return "1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// GetRandomHighlights returns up to limit random highlights matching
+// filter. Suspended highlights are never returned.
+func (db *Db) GetRandomHighlights(limit int, filter HighlightFilter) ([]models.Highlight, error) {
+	where, args := filter.where()
+	query := "SELECT " + highlightColumns + " FROM highlights WHERE NOT suspended AND " + where
+	rows, err := db.Query(query+" ORDER BY RANDOM() LIMIT ?", append(args, limit)...)
 	if err != nil {
 		log.Println("[db.go] Error querying highlights:", err)
 		return nil, err
@@ -89,8 +374,7 @@ func (db *Db) GetRandomHighlights(limit int) ([]models.Highlight, error) {
 	var randomHighlights []models.Highlight
 
 	for rows.Next() {
-		var highlight models.Highlight
-		err := rows.Scan(&highlight.Source, &highlight.SourceType, &highlight.Content)
+		highlight, err := scanHighlight(rows)
 		if err != nil {
 			log.Println("[db.go] Error scanning highlight:", err)
 			return nil, err
@@ -98,11 +382,27 @@ func (db *Db) GetRandomHighlights(limit int) ([]models.Highlight, error) {
 
 		randomHighlights = append(randomHighlights, highlight)
 	}
-	return randomHighlights, nil
+	return randomHighlights, db.attachHighlightDetails(randomHighlights)
+}
+
+func (db *Db) GetHighlight(id int) (models.Highlight, error) {
+	highlight, err := scanHighlight(db.QueryRow("SELECT "+highlightColumns+" FROM highlights WHERE id = ?", id))
+	if err != nil {
+		log.Println("[db.go] Error querying highlight:", id, err)
+		return highlight, err
+	}
+	highlights := []models.Highlight{highlight}
+	err = db.attachHighlightDetails(highlights)
+	return highlights[0], err
 }
 
-func (db *Db) GetSources() ([]models.Source, error) {
-	rows, err := db.Query("SELECT DISTINCT source, source_type FROM highlights")
+// GetSources returns every source with highlights matching filter, and
+// its metadata. A source tagged with filter.Tag matches through all of its
+// highlights.
+func (db *Db) GetSources(filter HighlightFilter) ([]models.Source, error) {
+	where, args := filter.where()
+	query := "SELECT source_id FROM highlights WHERE " + where
+	rows, err := db.Query("SELECT "+sourceColumns+" FROM sources s WHERE s.id IN ("+query+") ORDER BY s.id", args...)
 	if err != nil {
 		log.Println("[db.go] Error querying sources:", err)
 		return nil, err
@@ -113,22 +413,30 @@ func (db *Db) GetSources() ([]models.Source, error) {
 	var sources []models.Source
 
 	for rows.Next() {
-		var source models.Source
-		err := rows.Scan(&source.Name, &source.Type)
+		source, err := scanSource(rows)
 		if err != nil {
 			log.Println("[db.go] Error scanning source:", err)
 			return nil, err
 		}
 		sources = append(sources, source)
 	}
-	return sources, nil
+	if err := db.attachAuthors(sources); err != nil {
+		return nil, err
+	}
+	return sources, db.attachSourceTags(sources)
 
 }
 
-func (db *Db) GetSourceHighlights(source string) ([]models.Highlight, error) {
-	rows, err := db.Query("SELECT source, source_type, content FROM highlights WHERE source = ?", source)
+// readingOrder orders highlights by their position within the source,
+// those without one last, and then in the order they were imported.
+const readingOrder = `location NULLS LAST, page NULLS LAST, percent NULLS LAST,
+	timestamp_seconds NULLS LAST, id`
+
+// GetSourceHighlights returns the highlights of a source in reading order.
+func (db *Db) GetSourceHighlights(sourceID int) ([]models.Highlight, error) {
+	rows, err := db.Query("SELECT "+highlightColumns+" FROM highlights WHERE source_id = ? ORDER BY "+readingOrder, sourceID)
 	if err != nil {
-		log.Fatalf("Error querying highlights for source %s: %v", source, err)
+		log.Println("[db.go] Error querying highlights for source:", sourceID, err)
 		return nil, err
 	}
 
@@ -137,16 +445,41 @@ func (db *Db) GetSourceHighlights(source string) ([]models.Highlight, error) {
 	var highlights []models.Highlight
 
 	for rows.Next() {
-		var highlight models.Highlight
-		err := rows.Scan(&highlight.Source, &highlight.SourceType, &highlight.Content)
+		highlight, err := scanHighlight(rows)
 		if err != nil {
-			log.Fatal("Error scanning source highlights:", err)
+			log.Println("[db.go] Error scanning source highlights:", err)
 			return nil, err
 		}
 		highlights = append(highlights, highlight)
 	}
-	return highlights, nil
+	return highlights, db.attachHighlightDetails(highlights)
+
+}
+
+// GetHighlights returns every highlight matching filter with its tags,
+// pinned ones first, then ordered by source and by id.
+func (db *Db) GetHighlights(filter HighlightFilter) ([]models.Highlight, error) {
+	where, args := filter.where()
+	rows, err := db.Query("SELECT "+highlightColumns+" FROM highlights WHERE "+where+" ORDER BY pinned DESC, source, id", args...)
+	if err != nil {
+		log.Println("[db.go] Error querying highlights:", err)
+		return nil, err
+	}
+	defer rows.Close()
 
+	var highlights []models.Highlight
+	for rows.Next() {
+		highlight, err := scanHighlight(rows)
+		if err != nil {
+			log.Println("[db.go] Error scanning highlight:", err)
+			return nil, err
+		}
+		highlights = append(highlights, highlight)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return highlights, db.attachHighlightDetails(highlights)
 }
 
 func (db *Db) FlushTable(table_name string) error {
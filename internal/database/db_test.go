@@ -0,0 +1,116 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// openTestDB returns a library in a fresh database file, with its search
+// index.
+func openTestDB(t *testing.T) (*Db, *Search) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "highlights.db")
+	db, err := InitDb(path)
+	if err != nil {
+		t.Fatalf("InitDb: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	search, err := InitSearch(path)
+	if err != nil {
+		t.Fatalf("InitSearch: %v", err)
+	}
+	t.Cleanup(func() { search.Close() })
+	return db, search
+}
+
+// importTexts imports texts as highlights of the book named source and
+// returns the batch.
+func importTexts(t *testing.T, db *Db, source string, texts ...string) *models.ImportBatch {
+	t.Helper()
+	id, err := db.SourceFor(source, "book", 1)
+	if err != nil {
+		t.Fatalf("SourceFor: %v", err)
+	}
+	batch := &models.ImportBatch{Importer: "test", Format: "text", SourceID: id, Source: source, SourceType: "book"}
+	if err := db.ImportHighlights(batch, bookHighlights(source, texts...)); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+	return batch
+}
+
+// bookHighlights returns texts as highlights of the book named source.
+func bookHighlights(source string, texts ...string) []models.Highlight {
+	highlights := make([]models.Highlight, len(texts))
+	for i, text := range texts {
+		highlights[i] = models.Highlight{Source: source, SourceType: "book", Content: text}
+	}
+	return highlights
+}
+
+// sourceID returns the id of the first book named source, or 0 if there
+// is none.
+func sourceID(t *testing.T, db *Db, source string) int {
+	t.Helper()
+	id, err := db.LookupSource(source, "book", 1)
+	if err != nil {
+		t.Fatalf("LookupSource: %v", err)
+	}
+	return id
+}
+
+// findHighlight returns the highlight of the book named source with the
+// given content.
+func findHighlight(t *testing.T, db *Db, source, content string) models.Highlight {
+	t.Helper()
+	highlights, err := db.GetSourceHighlights(sourceID(t, db, source))
+	if err != nil {
+		t.Fatalf("GetSourceHighlights: %v", err)
+	}
+	for _, highlight := range highlights {
+		if highlight.Content == content {
+			return highlight
+		}
+	}
+	t.Fatalf("%s has no highlight %q", source, content)
+	return models.Highlight{}
+}
+
+func TestGetSourceHighlightsReadingOrder(t *testing.T) {
+	db, _ := openTestDB(t)
+	source, err := db.SourceFor("Atomic Habits", "book", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	highlights := []models.Highlight{
+		{Content: "No position, first imported."},
+		{Content: "Page 80.", Page: 80},
+		{Content: "Location 120.", Location: 120},
+		{Content: "No position, last imported."},
+		{Content: "Page 12.", Page: 12},
+		{Content: "Location 30.", Location: 30},
+	}
+	for i := range highlights {
+		highlights[i].Source, highlights[i].SourceType = "Atomic Habits", "book"
+	}
+	batch := &models.ImportBatch{Importer: "test", Format: "text", SourceID: source, Source: "Atomic Habits", SourceType: "book"}
+	if err := db.ImportHighlights(batch, highlights); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+
+	got, err := db.GetSourceHighlights(source)
+	if err != nil {
+		t.Fatalf("GetSourceHighlights: %v", err)
+	}
+	var contents []string
+	for _, highlight := range got {
+		contents = append(contents, highlight.Content)
+	}
+	// By location, then by page, then unplaced ones in import order
+	want := []string{"Location 30.", "Location 120.", "Page 12.", "Page 80.",
+		"No position, first imported.", "No position, last imported."}
+	if !slices.Equal(contents, want) {
+		t.Errorf("reading order %q, want %q", contents, want)
+	}
+}
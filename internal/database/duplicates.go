@@ -0,0 +1,284 @@
+package database
+
+import (
+	"database/sql"
+	"highlights-anki/internal/dedup"
+	"highlights-anki/internal/models"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DefaultNearDuplicateThreshold is the estimated Jaccard similarity of
+// character shingles above which two highlights are reported as near
+// duplicates.
+const DefaultNearDuplicateThreshold = 0.6
+
+// GetNearDuplicateHighlights groups highlights whose text is nearly the
+// same, such as the same passage imported with different punctuation or a
+// word changed. Pairs are found with MinHash signatures, so similarity is
+// an estimate; pairs dismissed with DismissDuplicates are left out.
+func (db *Db) GetNearDuplicateHighlights(threshold float64) ([][]models.Highlight, error) {
+	rows, err := db.Query("SELECT " + highlightColumns + " FROM highlights ORDER BY id")
+	if err != nil {
+		log.Println("[duplicates.go] Error querying highlights:", err)
+		return nil, err
+	}
+	var highlights []models.Highlight
+	var sigs []dedup.Signature
+	for rows.Next() {
+		highlight, err := scanHighlight(rows)
+		if err != nil {
+			rows.Close()
+			log.Println("[duplicates.go] Error scanning highlight:", err)
+			return nil, err
+		}
+		highlights = append(highlights, highlight)
+		sigs = append(sigs, dedup.Sign(highlight.Content))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dismissed, err := db.dismissedPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	// Union-find over the similar pairs, so that A~B and B~C end up in
+	// one group
+	parent := make([]int, len(highlights))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for _, pair := range dedup.Candidates(sigs) {
+		a, b := pair[0], pair[1]
+		if dismissed[[2]int{highlights[a].ID, highlights[b].ID}] {
+			continue
+		}
+		if dedup.Similarity(&sigs[a], &sigs[b]) < threshold {
+			continue
+		}
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[max(ra, rb)] = min(ra, rb)
+		}
+	}
+
+	byRoot := map[int][]models.Highlight{}
+	for i, highlight := range highlights {
+		root := find(i)
+		byRoot[root] = append(byRoot[root], highlight)
+	}
+	var groups [][]models.Highlight
+	for _, group := range byRoot {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].ID < groups[j][0].ID })
+	return groups, nil
+}
+
+// MergeHighlights keeps the highlight keep and deletes the others in ids,
+// moving their tags and notes onto keep; keep becomes a favorite or pinned
+// if any of them was. It returns how many were deleted, or sql.ErrNoRows
+// without deleting anything when keep is not one of ids or does not exist.
+func (db *Db) MergeHighlights(keep int, ids []int) (int, error) {
+	if !slices.Contains(ids, keep) {
+		return 0, sql.ErrNoRows
+	}
+	var found int
+	if err := db.QueryRow("SELECT COUNT(*) FROM highlights WHERE id = ?", keep).Scan(&found); err != nil {
+		log.Println("[duplicates.go] Error looking up merged highlight:", err)
+		return 0, err
+	}
+	if found == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	var remove []any
+	for _, id := range ids {
+		if id != keep {
+			remove = append(remove, id)
+		}
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[duplicates.go] Error beginning transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO highlight_tags (highlight_id, tag_id)
+		SELECT ?, tag_id FROM highlight_tags WHERE highlight_id IN (`+placeholders(len(remove))+`)`,
+		append([]any{keep}, remove...)...)
+	if err != nil {
+		log.Println("[duplicates.go] Error moving tags to merged highlight:", err)
+		return 0, err
+	}
+	if err := mergeNotes(tx, keep, remove); err != nil {
+		log.Println("[duplicates.go] Error moving notes to merged highlight:", err)
+		return 0, err
+	}
+	if err := mergeDetails(tx, keep, remove); err != nil {
+		log.Println("[duplicates.go] Error moving details to merged highlight:", err)
+		return 0, err
+	}
+
+	// Only update keep when it gains a flag, so its updated_at stays put
+	_, err = tx.Exec(`
+		UPDATE highlights SET
+			favorite = highlights.favorite OR o.favorite,
+			pinned = highlights.pinned OR o.pinned
+		FROM (SELECT MAX(favorite) AS favorite, MAX(pinned) AS pinned FROM highlights
+			WHERE id IN (`+placeholders(len(remove))+`)) o
+		WHERE highlights.id = ?
+			AND ((o.favorite AND NOT highlights.favorite) OR (o.pinned AND NOT highlights.pinned))`,
+		append(append([]any{}, remove...), keep)...)
+	if err != nil {
+		log.Println("[duplicates.go] Error moving flags to merged highlight:", err)
+		return 0, err
+	}
+
+	res, err := tx.Exec("DELETE FROM highlights WHERE id IN ("+placeholders(len(remove))+")", remove...)
+	if err != nil {
+		log.Println("[duplicates.go] Error deleting merged highlights:", err)
+		return 0, err
+	}
+	count, _ := res.RowsAffected()
+	return int(count), tx.Commit()
+}
+
+// mergeNotes sets the note of keep to its own note followed by the
+// distinct notes of the highlights in remove, separated by blank lines.
+func mergeNotes(tx *sql.Tx, keep int, remove []any) error {
+	rows, err := tx.Query(`
+		SELECT note FROM highlights
+		WHERE id IN (?, `+placeholders(len(remove))+`) AND note IS NOT NULL AND note != ''
+		ORDER BY id != ?, id`, append(append([]any{keep}, remove...), keep)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var notes []string
+	for rows.Next() {
+		var note string
+		if err := rows.Scan(&note); err != nil {
+			return err
+		}
+		if !slices.Contains(notes, note) {
+			notes = append(notes, note)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+	// Leave keep untouched, and its updated_at alone, when its note is all
+	// there is
+	merged := strings.Join(notes, "\n\n")
+	_, err = tx.Exec("UPDATE highlights SET note = ? WHERE id = ? AND note IS NOT ?", merged, keep, merged)
+	return err
+}
+
+// mergedDetails are the groups of highlight columns a merge carries over
+// to the kept highlight. A group is copied whole, and only when the kept
+// highlight has none of its columns set.
+var mergedDetails = [][]string{
+	{"episode_id", "timestamp_seconds"},
+	{"chapter"},
+	{"location", "page", "percent"},
+	{"color"},
+	{"kind"},
+}
+
+// mergeDetails fills in each group of mergedDetails that keep lacks from
+// the oldest highlight in remove of the same source that has it.
+func mergeDetails(tx *sql.Tx, keep int, remove []any) error {
+	args := append(append([]any{}, remove...), keep, keep)
+	for _, columns := range mergedDetails {
+		set := make([]string, len(columns))
+		has := make([]string, len(columns))
+		lacks := make([]string, len(columns))
+		for i, column := range columns {
+			set[i] = column + " = o." + column
+			has[i] = column + " IS NOT NULL"
+			lacks[i] = "highlights." + column + " IS NULL"
+		}
+		_, err := tx.Exec(`
+			UPDATE highlights SET `+strings.Join(set, ", ")+`
+			FROM (
+				SELECT `+strings.Join(columns, ", ")+` FROM highlights
+				WHERE id IN (`+placeholders(len(remove))+`) AND (`+strings.Join(has, " OR ")+`)
+					AND source_id IS (SELECT source_id FROM highlights WHERE id = ?)
+				ORDER BY id LIMIT 1
+			) o
+			WHERE highlights.id = ? AND `+strings.Join(lacks, " AND "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DismissDuplicates records that the given highlights are not duplicates
+// of each other, so they are no longer grouped together.
+func (db *Db) DismissDuplicates(ids []int) error {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[duplicates.go] Error beginning transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+	for i, a := range sorted {
+		for _, b := range sorted[i+1:] {
+			_, err := tx.Exec("INSERT OR IGNORE INTO duplicate_dismissals (id_a, id_b) VALUES (?, ?)", a, b)
+			if err != nil {
+				log.Println("[duplicates.go] Error dismissing duplicates:", err)
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Db) dismissedPairs() (map[[2]int]bool, error) {
+	rows, err := db.Query("SELECT id_a, id_b FROM duplicate_dismissals")
+	if err != nil {
+		log.Println("[duplicates.go] Error querying duplicate dismissals:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := map[[2]int]bool{}
+	for rows.Next() {
+		var pair [2]int
+		if err := rows.Scan(&pair[0], &pair[1]); err != nil {
+			return nil, err
+		}
+		pairs[pair] = true
+	}
+	return pairs, rows.Err()
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestNearDuplicates(t *testing.T) {
+	db, _ := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"You do not rise to the level of your goals. You fall to the level of your systems.",
+		"Habits are the compound interest of self-improvement.")
+	importTexts(t, db, "Atomic Habits (Kindle)",
+		"You do not rise to the level of your goals; you fall to the level of your systems!")
+
+	groups, err := db.GetNearDuplicateHighlights(DefaultNearDuplicateThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0].ID != 1 || groups[0][1].ID != 3 {
+		t.Fatalf("groups = %+v, want highlights 1 and 3", groups)
+	}
+
+	if err := db.DismissDuplicates([]int{3, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := db.GetNearDuplicateHighlights(DefaultNearDuplicateThreshold); len(groups) != 0 {
+		t.Errorf("dismissed pair still grouped: %+v", groups)
+	}
+}
+
+func TestMergeHighlights(t *testing.T) {
+	db, _ := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Systems, not goals.", "Systems not goals", "Habits compound.")
+
+	// Nothing is deleted unless the kept highlight is one of the merged
+	for _, keep := range []int{3, 99} {
+		if _, err := db.MergeHighlights(keep, []int{1, 2}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("MergeHighlights keeping %d = %v, want sql.ErrNoRows", keep, err)
+		}
+	}
+	if _, err := db.MergeHighlights(99, []int{1, 99}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("MergeHighlights keeping a missing highlight = %v, want sql.ErrNoRows", err)
+	}
+
+	count, err := db.MergeHighlights(2, []int{1, 2})
+	if err != nil || count != 1 {
+		t.Fatalf("MergeHighlights = %d, %v; want 1 deleted", count, err)
+	}
+	highlights, err := db.GetSourceHighlights(sourceID(t, db, "Atomic Habits"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, highlight := range highlights {
+		ids = append(ids, highlight.ID)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []int{2, 3}) {
+		t.Errorf("left highlights %v, want 2 and 3", ids)
+	}
+}
+
+func TestMergeHighlightsCarriesDetails(t *testing.T) {
+	db, _ := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Systems, not goals.", "Systems not goals", "systems, not goals!")
+	keep := findHighlight(t, db, "Atomic Habits", "Systems, not goals.")
+	first := findHighlight(t, db, "Atomic Habits", "Systems not goals")
+	second := findHighlight(t, db, "Atomic Habits", "systems, not goals!")
+	for id, note := range map[int]string{keep.ID: "Mine.", first.ID: "From the Kindle.", second.ID: "Mine."} {
+		if err := db.SetHighlightNote(id, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := sourceID(t, db, "Atomic Habits")
+	if _, err := db.ImportEpisodes(source, "", []models.Episode{{GUID: "ep-1", Title: "On systems"}}); err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := db.GetEpisodes(source)
+	if err != nil || len(episodes) != 1 {
+		t.Fatalf("GetEpisodes = %v, %v", episodes, err)
+	}
+	if err := db.SetHighlightEpisode(second.ID, episodes[0].ID, 125); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE highlights SET chapter = 'Chapter 1', page = 42, kind = 'note' WHERE id = ?", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetHighlightColor(second.ID, "yellow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetHighlightFlag(first.ID, "favorite", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetHighlightFlag(second.ID, "pinned", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.MergeHighlights(keep.ID, []int{keep.ID, first.ID, second.ID}); err != nil {
+		t.Fatalf("MergeHighlights: %v", err)
+	}
+	merged := findHighlight(t, db, "Atomic Habits", "Systems, not goals.")
+	if want := "Mine.\n\nFrom the Kindle."; merged.Note != want {
+		t.Errorf("merged note %q, want %q", merged.Note, want)
+	}
+	if merged.EpisodeID != episodes[0].ID || merged.Timestamp != 125 {
+		t.Errorf("merged episode %d at %d, want %d at 125", merged.EpisodeID, merged.Timestamp, episodes[0].ID)
+	}
+	if merged.Chapter != "Chapter 1" || merged.Page != 42 {
+		t.Errorf("merged position %q p. %d, want Chapter 1 p. 42", merged.Chapter, merged.Page)
+	}
+	if merged.Color != "yellow" || merged.Kind != "note" {
+		t.Errorf("merged color %q and kind %q, want yellow and note", merged.Color, merged.Kind)
+	}
+	if !merged.Favorite || !merged.Pinned {
+		t.Errorf("merged favorite %t and pinned %t, want both", merged.Favorite, merged.Pinned)
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"highlights-anki/internal/models"
+	"log"
+)
+
+func createEpisodesTable(db *sql.DB) error {
+	// Episodes belong to a source by id, like highlights. guid is the
+	// feed's own id for an episode, so re-reading a feed updates episodes
+	// in place instead of adding them again.
+	createEpisodesTableQuery := `
+	CREATE TABLE IF NOT EXISTS podcast_episodes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source_id INTEGER NOT NULL,
+		guid TEXT NOT NULL,
+		title TEXT,
+		published_at TEXT,
+		audio_url TEXT,
+		duration INTEGER,
+		UNIQUE (source_id, guid)
+	);`
+
+	_, err := db.Exec(createEpisodesTableQuery)
+	return err
+}
+
+// episodeColumns are the columns read by scanEpisode, in order.
+const episodeColumns = "id, source_id, guid, title, published_at, audio_url, duration"
+
+func scanEpisode(row rowScanner) (models.Episode, error) {
+	var episode models.Episode
+	var title, publishedAt, audioURL sql.NullString
+	var duration sql.NullInt64
+	err := row.Scan(&episode.ID, &episode.SourceID, &episode.GUID, &title, &publishedAt, &audioURL, &duration)
+	if err != nil {
+		return episode, err
+	}
+	episode.Title = title.String
+	episode.PublishedAt = parseTime(publishedAt)
+	episode.AudioURL = audioURL.String
+	episode.Duration = int(duration.Int64)
+	return episode, nil
+}
+
+// ImportEpisodes adds the episodes of a podcast source, updating those
+// already stored with the same GUID, and returns how many were new. When
+// feedURL is set it is recorded as the source's feed.
+func (db *Db) ImportEpisodes(sourceID int, feedURL string, episodes []models.Episode) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[episodes.go] Error beginning transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	added := 0
+	for _, episode := range episodes {
+		var existing int
+		err := tx.QueryRow("SELECT COUNT(*) FROM podcast_episodes WHERE source_id = ? AND guid = ?", sourceID, episode.GUID).Scan(&existing)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO podcast_episodes (source_id, guid, title, published_at, audio_url, duration)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (source_id, guid) DO UPDATE SET
+				title = excluded.title,
+				published_at = excluded.published_at,
+				audio_url = excluded.audio_url,
+				duration = excluded.duration`,
+			sourceID, episode.GUID, episode.Title, nullTime(episode.PublishedAt), nullString(episode.AudioURL), episode.Duration)
+		if err != nil {
+			log.Println("[episodes.go] Error saving episode:", episode.GUID, err)
+			return 0, err
+		}
+		if existing == 0 {
+			added++
+		}
+	}
+
+	if feedURL != "" {
+		_, err := tx.Exec("UPDATE sources SET feed_url = ? WHERE id = ?", feedURL, sourceID)
+		if err != nil {
+			log.Println("[episodes.go] Error saving feed URL:", sourceID, err)
+			return 0, err
+		}
+	}
+	return added, tx.Commit()
+}
+
+// GetEpisodes returns the episodes of a podcast source, newest first.
+func (db *Db) GetEpisodes(sourceID int) ([]models.Episode, error) {
+	rows, err := db.Query("SELECT "+episodeColumns+" FROM podcast_episodes WHERE source_id = ? ORDER BY published_at DESC, id DESC", sourceID)
+	if err != nil {
+		log.Println("[episodes.go] Error querying episodes:", sourceID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []models.Episode
+	for rows.Next() {
+		episode, err := scanEpisode(rows)
+		if err != nil {
+			log.Println("[episodes.go] Error scanning episode:", err)
+			return nil, err
+		}
+		episodes = append(episodes, episode)
+	}
+	return episodes, rows.Err()
+}
+
+// SetHighlightEpisode links a highlight to an episode of its own source
+// and a timestamp in it, in seconds. An episodeID of 0 removes the link.
+// It returns sql.ErrNoRows if there is no such highlight or the episode is
+// not from its source.
+func (db *Db) SetHighlightEpisode(id, episodeID, timestamp int) error {
+	var res sql.Result
+	var err error
+	if episodeID == 0 {
+		res, err = db.Exec("UPDATE highlights SET episode_id = NULL, timestamp_seconds = NULL WHERE id = ?", id)
+	} else {
+		res, err = db.Exec(`
+			UPDATE highlights SET episode_id = ?, timestamp_seconds = ?
+			WHERE id = ? AND EXISTS (
+				SELECT 1 FROM podcast_episodes e WHERE e.id = ? AND e.source_id = highlights.source_id
+			)`, episodeID, nullInt(timestamp), id, episodeID)
+	}
+	if err != nil {
+		log.Println("[episodes.go] Error linking highlight to episode:", id, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// attachEpisodes fills in the Episode of each highlight linked to one.
+func (db *Db) attachEpisodes(highlights []models.Highlight) error {
+	var args []any
+	for _, highlight := range highlights {
+		if highlight.EpisodeID != 0 {
+			args = append(args, highlight.EpisodeID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT "+episodeColumns+" FROM podcast_episodes WHERE id IN ("+placeholders(len(args))+")", args...)
+	if err != nil {
+		log.Println("[episodes.go] Error querying highlight episodes:", err)
+		return err
+	}
+	defer rows.Close()
+
+	byID := map[int]*models.Episode{}
+	for rows.Next() {
+		episode, err := scanEpisode(rows)
+		if err != nil {
+			return err
+		}
+		byID[episode.ID] = &episode
+	}
+	for i := range highlights {
+		highlights[i].Episode = byID[highlights[i].EpisodeID]
+	}
+	return rows.Err()
+}
+
+// attachHighlightDetails fills in the tags and episodes of highlights read
+// with highlightColumns.
+func (db *Db) attachHighlightDetails(highlights []models.Highlight) error {
+	if err := db.attachTags(highlights); err != nil {
+		return err
+	}
+	return db.attachEpisodes(highlights)
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"highlights-anki/internal/models"
+	"log"
+	"slices"
+)
+
+// SetHighlightFlag turns one of models.HighlightFlags on or off for a
+// highlight. It returns sql.ErrNoRows if there is no highlight with that id.
+func (db *Db) SetHighlightFlag(id int, flag string, on bool) error {
+	if !slices.Contains(models.HighlightFlags, flag) {
+		return fmt.Errorf("unknown highlight flag %q", flag)
+	}
+	// flag is one of the known column names, so it is safe to splice in
+	res, err := db.Exec("UPDATE highlights SET "+flag+" = ? WHERE id = ?", on, id)
+	if err != nil {
+		log.Println("[flags.go] Error setting highlight flag:", id, flag, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
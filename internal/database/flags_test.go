@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHighlightFlags(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits compound.", "Habits shape identity.", "Habits need cues.")
+	setFlag := func(content, flag string, on bool) {
+		t.Helper()
+		if err := db.SetHighlightFlag(findHighlight(t, db, "Atomic Habits", content).ID, flag, on); err != nil {
+			t.Fatalf("SetHighlightFlag %s: %v", flag, err)
+		}
+	}
+	setFlag("Habits compound.", "favorite", true)
+	setFlag("Habits need cues.", "pinned", true)
+	setFlag("Habits shape identity.", "suspended", true)
+	if err := db.SetHighlightFlag(-1, "favorite", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetHighlightFlag of a missing highlight = %v, want sql.ErrNoRows", err)
+	}
+	if err := db.SetHighlightFlag(1, "id = 0, content", true); err == nil {
+		t.Error("SetHighlightFlag took a flag that is not one")
+	}
+
+	random, err := db.GetRandomHighlights(10, HighlightFilter{})
+	if err != nil {
+		t.Fatalf("GetRandomHighlights: %v", err)
+	}
+	for _, highlight := range random {
+		if highlight.Suspended {
+			t.Errorf("random review returned suspended %q", highlight.Content)
+		}
+	}
+	favorites, err := db.GetRandomHighlights(10, HighlightFilter{Favorite: true})
+	if err != nil || len(favorites) != 1 || favorites[0].Content != "Habits compound." {
+		t.Errorf("favorites = %+v, %v; want Habits compound.", favorites, err)
+	}
+	all, err := db.GetHighlights(HighlightFilter{})
+	if err != nil || len(all) != 3 || all[0].Content != "Habits need cues." {
+		t.Errorf("GetHighlights = %+v, %v; want the pinned highlight first", all, err)
+	}
+
+	// Search leaves suspended highlights out unless asked for them
+	for query, want := range map[string][]string{
+		"habits":               {"Habits compound.", "Habits need cues."},
+		"habits is:suspended":  {"Habits shape identity."},
+		"habits is:Favorite":   {"Habits compound."},
+		"habits -is:pinned":    {"Habits compound."},
+		"habits -is:suspended": {"Habits compound.", "Habits need cues."},
+	} {
+		page, err := search.GetSearchResults(query, SearchOptions{Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got []string
+		for _, result := range page.Results {
+			got = append(got, result.Content)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %q, want %q", query, got, want)
+		}
+	}
+	var queryErr *QueryError
+	if _, err := search.GetSearchResults("is:archived", SearchOptions{Limit: 10}); !errors.As(err, &queryErr) {
+		t.Errorf("is:archived = %v, want a QueryError", err)
+	}
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"highlights-anki/internal/models"
+	"log"
+	"time"
+)
+
+func createImportBatchesTable(db *sql.DB) error {
+	createImportBatchesQuery := `
+	CREATE TABLE IF NOT EXISTS import_batches (
+		id TEXT PRIMARY KEY,
+		importer TEXT NOT NULL,
+		format TEXT NOT NULL,
+		file_name TEXT,
+		source_id INTEGER,
+		source TEXT NOT NULL,
+		source_type TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		inserted INTEGER NOT NULL DEFAULT 0,
+		skipped INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		undone_at TEXT
+	);`
+
+	_, err := db.Exec(createImportBatchesQuery)
+	if err != nil {
+		return err
+	}
+	return migrateColumns(db, "import_batches", addedImportBatchColumns)
+}
+
+// addedImportBatchColumns are the columns of import_batches that databases
+// created by older versions may lack.
+var addedImportBatchColumns = []string{"source_id INTEGER"}
+
+// newBatchID returns an identifier for one import. IDs sort by the time the
+// import started.
+func newBatchID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// ImportHighlights inserts highlights as one import batch into the source
+// batch.SourceID in a single transaction, skipping any whose content hash
+// the source already has (including earlier ones in the same batch).
+// Highlights stored before normalization hash their original text, so
+// that is looked for too. It fills in the batch's ID, creation time and
+// counts; batch.Failed is left as the caller set it.
+func (db *Db) ImportHighlights(batch *models.ImportBatch, highlights []models.Highlight) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		println("Error beginning transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO highlights (source_id, source, source_type, content, raw_content, content_hash,
+			created_at, updated_at, highlighted_at, import_batch, importer,
+			chapter, location, page, percent, timestamp_seconds, color, kind)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM highlights WHERE source_id = ? AND content_hash IN (?, ?))`)
+	if err != nil {
+		println("Error preparing statement:", err)
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	batch.ID = newBatchID()
+	batch.CreatedAt = now.UTC().Truncate(time.Second)
+	batch.Inserted, batch.Skipped = 0, 0
+
+	for i := range highlights {
+		highlight := &highlights[i]
+		highlight.ImportBatch = batch.ID
+		highlight.Importer = batch.Importer
+		highlight.SourceID = batch.SourceID
+		hash := ContentHash(highlight.Content)
+		res, err := stmt.Exec(batch.SourceID, highlight.Source, highlight.SourceType, highlight.Content, nullString(highlight.RawContent), hash,
+			formatTime(now), formatTime(now), nullTime(highlight.HighlightedAt), batch.ID, nullString(batch.Importer),
+			nullString(highlight.Chapter), nullInt(highlight.Location), nullInt(highlight.Page),
+			nullInt(highlight.Percent), nullInt(highlight.Timestamp),
+			nullString(highlight.Color), nullString(highlight.Kind),
+			batch.SourceID, hash, ContentHash(highlight.Original()))
+		if err != nil {
+			println("Error inserting highlight:", err)
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			batch.Skipped++
+			continue
+		}
+		batch.Inserted++
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO import_batches (id, importer, format, file_name, source_id, source, source_type, created_at, inserted, skipped, failed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		batch.ID, batch.Importer, batch.Format, nullString(batch.FileName), batch.SourceID, batch.Source, batch.SourceType,
+		formatTime(now), batch.Inserted, batch.Skipped, batch.Failed)
+	if err != nil {
+		log.Println("[imports.go] Error recording import batch:", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		println("Error committing transaction:", err)
+		return err
+	}
+	return nil
+}
+
+// PreviewImport reports which of highlights ImportHighlights would skip
+// when importing them into the source sourceID, without changing anything.
+// A sourceID of 0 is a source yet to be created, which has none.
+func (db *Db) PreviewImport(sourceID int, highlights []models.Highlight) ([]bool, error) {
+	skipped := make([]bool, len(highlights))
+	seen := map[string]bool{}
+	for i, highlight := range highlights {
+		hash := ContentHash(highlight.Content)
+		if seen[hash] {
+			skipped[i] = true
+			continue
+		}
+		seen[hash] = true
+
+		var found int
+		err := db.QueryRow("SELECT COUNT(*) FROM highlights WHERE source_id = ? AND content_hash IN (?, ?)",
+			sourceID, hash, ContentHash(highlight.Original())).Scan(&found)
+		if err != nil {
+			log.Println("[imports.go] Error checking for existing highlight:", err)
+			return nil, err
+		}
+		skipped[i] = found > 0
+	}
+	return skipped, nil
+}
+
+// importBatchColumns are the columns scanImportBatch reads, in order.
+const importBatchColumns = `id, importer, format, file_name, source_id, source, source_type, created_at,
+	inserted, skipped, failed, undone_at`
+
+// scanImportBatch reads an import batch selected with importBatchColumns.
+func scanImportBatch(row rowScanner) (models.ImportBatch, error) {
+	var batch models.ImportBatch
+	var fileName, createdAt, undoneAt sql.NullString
+	var sourceID sql.NullInt64
+	err := row.Scan(&batch.ID, &batch.Importer, &batch.Format, &fileName, &sourceID, &batch.Source, &batch.SourceType,
+		&createdAt, &batch.Inserted, &batch.Skipped, &batch.Failed, &undoneAt)
+	batch.FileName = fileName.String
+	batch.SourceID = int(sourceID.Int64)
+	batch.CreatedAt = parseTime(createdAt)
+	batch.UndoneAt = parseTime(undoneAt)
+	return batch, err
+}
+
+// GetImportBatches returns the most recent import batches, newest first.
+func (db *Db) GetImportBatches(limit int) ([]models.ImportBatch, error) {
+	rows, err := db.Query(`
+		SELECT `+importBatchColumns+`
+		FROM import_batches
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		log.Println("[imports.go] Error querying import batches:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []models.ImportBatch
+	for rows.Next() {
+		batch, err := scanImportBatch(rows)
+		if err != nil {
+			log.Println("[imports.go] Error scanning import batch:", err)
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// GetImportBatch returns the import batch with the given id, or
+// sql.ErrNoRows if there is none.
+func (db *Db) GetImportBatch(id string) (models.ImportBatch, error) {
+	batch, err := scanImportBatch(db.QueryRow("SELECT "+importBatchColumns+" FROM import_batches WHERE id = ?", id))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("[imports.go] Error querying import batch:", id, err)
+	}
+	return batch, err
+}
+
+// GetBatchHighlights returns the highlights still in the library from an
+// import batch.
+func (db *Db) GetBatchHighlights(batchID string) ([]models.Highlight, error) {
+	rows, err := db.Query("SELECT "+highlightColumns+" FROM highlights WHERE import_batch = ? ORDER BY id", batchID)
+	if err != nil {
+		log.Println("[imports.go] Error querying batch highlights:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var highlights []models.Highlight
+	for rows.Next() {
+		highlight, err := scanHighlight(rows)
+		if err != nil {
+			log.Println("[imports.go] Error scanning batch highlight:", err)
+			return nil, err
+		}
+		highlights = append(highlights, highlight)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return highlights, db.attachHighlightDetails(highlights)
+}
+
+// UndoImport deletes every highlight that an import batch inserted and
+// marks the batch as undone. Highlights it skipped as duplicates belonged
+// to earlier imports and are kept. It returns how many were deleted, or
+// sql.ErrNoRows if there is no such batch.
+func (db *Db) UndoImport(batchID string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[imports.go] Error beginning transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE import_batches SET undone_at = ? WHERE id = ?", formatTime(time.Now()), batchID)
+	if err != nil {
+		log.Println("[imports.go] Error marking import batch undone:", err)
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	res, err = tx.Exec("DELETE FROM highlights WHERE import_batch = ?", batchID)
+	if err != nil {
+		log.Println("[imports.go] Error deleting batch highlights:", err)
+		return 0, err
+	}
+	count, _ := res.RowsAffected()
+	return int(count), tx.Commit()
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"testing"
+)
+
+func TestImportHighlightsSkipsKnownContent(t *testing.T) {
+	db, _ := openTestDB(t)
+	texts := []string{
+		"Habits are the compound interest of self-improvement.",
+		"You do not rise to the level of your goals.",
+		"Every action you take is a vote.",
+	}
+	first := importTexts(t, db, "Atomic Habits", texts...)
+	if first.Inserted != 3 || first.Skipped != 0 {
+		t.Fatalf("first import inserted %d and skipped %d, want 3 and 0", first.Inserted, first.Skipped)
+	}
+
+	tests := []struct {
+		name              string
+		source            string
+		texts             []string
+		inserted, skipped int
+	}{
+		{"same file again", "Atomic Habits", texts, 0, 3},
+		{"case and whitespace", "Atomic Habits", []string{"  HABITS are the compound\tinterest of self-improvement. "}, 0, 1},
+		{"new and known", "Atomic Habits", []string{texts[0], "A new highlight."}, 1, 1},
+		{"repeated in one import", "Atomic Habits", []string{"Twice.", "twice."}, 1, 1},
+		{"another source", "Clear Thinking", texts[:1], 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, err := db.PreviewImport(sourceID(t, db, tt.source), bookHighlights(tt.source, tt.texts...))
+			if err != nil {
+				t.Fatalf("PreviewImport: %v", err)
+			}
+			previewSkipped := 0
+			for _, skip := range preview {
+				if skip {
+					previewSkipped++
+				}
+			}
+
+			batch := importTexts(t, db, tt.source, tt.texts...)
+			if batch.Inserted != tt.inserted || batch.Skipped != tt.skipped {
+				t.Errorf("inserted %d and skipped %d, want %d and %d", batch.Inserted, batch.Skipped, tt.inserted, tt.skipped)
+			}
+			if previewSkipped != batch.Skipped {
+				t.Errorf("preview skipped %d, import skipped %d", previewSkipped, batch.Skipped)
+			}
+		})
+	}
+
+	groups, err := db.GetDuplicateHighlights()
+	if err != nil {
+		t.Fatalf("GetDuplicateHighlights: %v", err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("re-imports left duplicates: %v", groups)
+	}
+}
+
+func TestUndoImport(t *testing.T) {
+	db, _ := openTestDB(t)
+	first := importTexts(t, db, "Atomic Habits", "Habits compound.", "Systems over goals.")
+	second := importTexts(t, db, "Atomic Habits", "Habits compound.", "Identity first.")
+
+	deleted, err := db.UndoImport(second.ID)
+	if err != nil {
+		t.Fatalf("UndoImport: %v", err)
+	}
+	// The highlight the second import skipped belongs to the first
+	if deleted != 1 {
+		t.Errorf("UndoImport deleted %d highlights, want 1", deleted)
+	}
+	findHighlight(t, db, "Atomic Habits", "Habits compound.")
+	findHighlight(t, db, "Atomic Habits", "Systems over goals.")
+	if highlights, err := db.GetSourceHighlights(sourceID(t, db, "Atomic Habits")); err != nil || len(highlights) != 2 {
+		t.Errorf("left %d highlights (%v), want the first import's 2", len(highlights), err)
+	}
+
+	batch, err := db.GetImportBatch(second.ID)
+	if err != nil {
+		t.Fatalf("GetImportBatch: %v", err)
+	}
+	if batch.UndoneAt.IsZero() || batch.Inserted != 1 || batch.Skipped != 1 {
+		t.Errorf("undone batch %+v, want UndoneAt set, 1 inserted and 1 skipped", batch)
+	}
+	if batch, err := db.GetImportBatch(first.ID); err != nil || !batch.UndoneAt.IsZero() {
+		t.Errorf("first batch %+v (%v), want it not undone", batch, err)
+	}
+
+	if _, err := db.UndoImport("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UndoImport of an unknown batch = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := db.GetImportBatch("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetImportBatch of an unknown batch = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestImportHighlightsSkipsOriginalText(t *testing.T) {
+	db, _ := openTestDB(t)
+	// Stored before normalization, with a soft hyphen
+	original := "Simpli\u00adfy, simplify."
+	importTexts(t, db, "Walden", original)
+
+	normalized := bookHighlights("Walden", "Simplify, simplify.")
+	normalized[0].RawContent = original
+	preview, err := db.PreviewImport(sourceID(t, db, "Walden"), normalized)
+	if err != nil || !preview[0] {
+		t.Errorf("PreviewImport = %v, %v; want the highlight skipped", preview, err)
+	}
+	batch := &models.ImportBatch{Importer: "test", Format: "text", SourceID: sourceID(t, db, "Walden"), Source: "Walden", SourceType: "book"}
+	if err := db.ImportHighlights(batch, normalized); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+	if batch.Inserted != 0 || batch.Skipped != 1 {
+		t.Errorf("inserted %d and skipped %d, want the original text found", batch.Inserted, batch.Skipped)
+	}
+
+	// Normalized highlights keep the text they were imported with
+	normalized = bookHighlights("Walden", "Go to the woods.")
+	normalized[0].RawContent = "Go to the\u200b woods."
+	if err := db.ImportHighlights(batch, normalized); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+	if got := findHighlight(t, db, "Walden", "Go to the woods."); got.Original() != "Go to the\u200b woods." {
+		t.Errorf("Original = %q, want the text as imported", got.Original())
+	}
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"fmt"
+	"highlights-anki/internal/models"
+	"log"
+	"strings"
+)
+
+// IntegrityCheck runs SQLite's PRAGMA integrity_check and returns the
+// problems it reports, or nil when the database file is sound.
+func (db *Db) IntegrityCheck() ([]string, error) {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		log.Println("[integrity.go] Error running integrity check:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// GetDuplicateHighlights returns groups of highlights with the same source
+// and content hash, oldest first within each group.
+func (db *Db) GetDuplicateHighlights() ([][]models.Highlight, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.source_id, h.source, h.source_type, h.content, h.content_hash
+		FROM highlights h
+		JOIN (
+			SELECT source_id, content_hash FROM highlights
+			GROUP BY source_id, content_hash
+			HAVING COUNT(*) > 1
+		) d ON d.source_id = h.source_id AND d.content_hash = h.content_hash
+		ORDER BY h.source_id, h.content_hash, h.id`)
+	if err != nil {
+		log.Println("[integrity.go] Error querying duplicate highlights:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups [][]models.Highlight
+	lastHash := ""
+	for rows.Next() {
+		var highlight models.Highlight
+		var hash string
+		err := rows.Scan(&highlight.ID, &highlight.SourceID, &highlight.Source, &highlight.SourceType, &highlight.Content, &hash)
+		if err != nil {
+			log.Println("[integrity.go] Error scanning duplicate highlight:", err)
+			return nil, err
+		}
+		last := len(groups) - 1
+		if last >= 0 && groups[last][0].SourceID == highlight.SourceID && hash == lastHash {
+			groups[last] = append(groups[last], highlight)
+		} else {
+			groups = append(groups, []models.Highlight{highlight})
+		}
+		lastHash = hash
+	}
+	return groups, rows.Err()
+}
+
+// DeleteHighlights deletes the highlights with the given ids in one
+// transaction and returns how many were deleted.
+func (db *Db) DeleteHighlights(ids []int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[integrity.go] Error beginning transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, id := range ids {
+		res, err := tx.Exec("DELETE FROM highlights WHERE id = ?", id)
+		if err != nil {
+			log.Println("[integrity.go] Error deleting highlight:", id, err)
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		count += int(n)
+	}
+	return count, tx.Commit()
+}
+
+// GetEmptySources returns the sources no highlight belongs to, such as
+// those whose every import was undone.
+func (db *Db) GetEmptySources() ([]models.Source, error) {
+	rows, err := db.Query(`
+		SELECT ` + sourceColumns + ` FROM sources s
+		WHERE NOT EXISTS (SELECT 1 FROM highlights WHERE source_id = s.id)
+		ORDER BY s.id`)
+	if err != nil {
+		log.Println("[integrity.go] Error querying empty sources:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []models.Source
+	for rows.Next() {
+		source, err := scanSource(rows)
+		if err != nil {
+			log.Println("[integrity.go] Error scanning empty source:", err)
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// DeleteSources deletes the sources with the given ids, with their
+// authors, tags and episodes, in one transaction and returns how many were
+// deleted.
+func (db *Db) DeleteSources(ids []int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[integrity.go] Error beginning transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, id := range ids {
+		for _, query := range []string{
+			"DELETE FROM source_authors WHERE source_id = ?",
+			"DELETE FROM source_tags WHERE source_id = ?",
+			"DELETE FROM podcast_episodes WHERE source_id = ?",
+		} {
+			if _, err := tx.Exec(query, id); err != nil {
+				log.Println("[integrity.go] Error deleting source details:", id, err)
+				return 0, err
+			}
+		}
+		res, err := tx.Exec("DELETE FROM sources WHERE id = ?", id)
+		if err != nil {
+			log.Println("[integrity.go] Error deleting source:", id, err)
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		count += int(n)
+	}
+	return count, tx.Commit()
+}
+
+// CheckIndexes compares the search indexes with the highlights table and
+// returns a description of every mismatch found. Reindex repairs all of
+// them.
+func (search *Search) CheckIndexes() ([]string, error) {
+	var problems []string
+	for _, name := range []string{"highlights_fts", "highlights_trigram"} {
+		// The _docsize shadow table has one row per indexed document, so it
+		// tells what the index holds rather than what the content table has
+		var indexed, missing, orphans int
+		err := search.QueryRow(`
+			SELECT
+				(SELECT COUNT(*) FROM `+name+`_docsize),
+				(SELECT COUNT(*) FROM highlights WHERE id NOT IN (SELECT id FROM `+name+`_docsize)),
+				(SELECT COUNT(*) FROM `+name+`_docsize WHERE id NOT IN (SELECT id FROM highlights))`).
+			Scan(&indexed, &missing, &orphans)
+		if err != nil {
+			log.Println("Error counting indexed rows:", name, err)
+			return nil, err
+		}
+		if missing > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d highlights are not indexed", name, missing))
+		}
+		if orphans > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %d indexed rows have no highlight", name, orphans))
+		}
+
+		// Catches rows whose indexed text no longer matches the highlight
+		_, err = search.Exec("INSERT INTO " + name + " (" + name + ", rank) VALUES ('integrity-check', 1)")
+		if err != nil {
+			if !strings.Contains(err.Error(), "malformed") {
+				log.Println("Error checking FTS index:", name, err)
+				return nil, err
+			}
+			problems = append(problems, fmt.Sprintf("%s: index does not match the highlights table", name))
+		}
+	}
+
+	var vectors int
+	err := search.QueryRow("SELECT COUNT(*) FROM semantic_vectors WHERE fts_rowid NOT IN (SELECT id FROM highlights)").Scan(&vectors)
+	if err != nil {
+		log.Println("Error counting orphan semantic vectors:", err)
+		return nil, err
+	}
+	if vectors > 0 {
+		problems = append(problems, fmt.Sprintf("semantic_vectors: %d vectors have no highlight", vectors))
+	}
+	return problems, nil
+}
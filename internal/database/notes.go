@@ -0,0 +1,22 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// SetHighlightNote replaces the note of a highlight; an empty note removes
+// it. It returns sql.ErrNoRows if there is no highlight with that id.
+func (db *Db) SetHighlightNote(id int, note string) error {
+	note = strings.TrimSpace(note)
+	res, err := db.Exec("UPDATE highlights SET note = ? WHERE id = ?", nullString(note), id)
+	if err != nil {
+		log.Println("[notes.go] Error setting highlight note:", id, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
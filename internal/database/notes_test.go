@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSearchNotes(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.", "Every action is a vote.")
+	habits := findHighlight(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.")
+	if err := db.SetHighlightNote(habits.ID, "  Like *interest* on savings.\n"); err != nil {
+		t.Fatalf("SetHighlightNote: %v", err)
+	}
+
+	for _, mode := range []string{SearchModeWords, SearchModeSubstring} {
+		page, err := search.GetSearchResults("savings", SearchOptions{Mode: mode, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Results) != 1 {
+			t.Fatalf("%s search for a note term found %d results, want 1", mode, len(page.Results))
+		}
+		result := page.Results[0]
+		if result.Note != "Like *interest* on savings." {
+			t.Errorf("%s search note %q, want the stored note", mode, result.Note)
+		}
+		if !strings.Contains(string(result.NoteHighlighted), "<mark>savings</mark>") {
+			t.Errorf("%s search marked note %q, want savings marked", mode, result.NoteHighlighted)
+		}
+	}
+
+	// Clearing the note takes it out of the index
+	if err := db.SetHighlightNote(habits.ID, " "); err != nil {
+		t.Fatal(err)
+	}
+	page, err := search.GetSearchResults("savings", SearchOptions{Mode: SearchModeWords, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 0 {
+		t.Errorf("cleared note still found: %+v", page.Results)
+	}
+
+	if err := db.SetHighlightNote(-1, "Nowhere."); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetHighlightNote of a missing highlight = %v, want sql.ErrNoRows", err)
+	}
+}
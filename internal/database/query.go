@@ -0,0 +1,612 @@
+package database
+
+import (
+	"fmt"
+	"highlights-anki/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QueryError is returned for search input that cannot be turned into a
+// query. Its message is safe to show to the user.
+type QueryError struct {
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return e.Msg
+}
+
+// Filter narrows a search by a field of the highlight rather than by its
+// text, e.g. source:"Atomic Habits" or added:>2025-01-01.
+type Filter struct {
+	Field  string
+	Op     string
+	Value  string
+	Negate bool
+}
+
+// SearchQuery is a parsed search. The text part is kept as an expression
+// tree so it can be rendered for either FTS index; every term is rendered
+// as a quoted FTS5 string, so the result never fails to parse in SQLite.
+type SearchQuery struct {
+	Filters []Filter
+	// include must match for a highlight to be returned. Nil when the query
+	// has only filters or negated terms.
+	include queryNode
+	// exclude removes highlights matching any of its items. Only set when
+	// include is nil, since FTS5 cannot express a NOT without a left-hand
+	// side.
+	exclude []queryNode
+}
+
+// FilterFields are the field names recognized before a colon.
+var FilterFields = []string{"source", "type", "tag", "author", "color", "kind", "is", "added"}
+
+// ParseQuery turns user search input into a SearchQuery.
+//
+// Supported syntax: bare words (AND-ed), "quoted phrases", prefix*, OR,
+// -word or NOT word, a NEAR b or a NEAR/5 b, parentheses, and the filters
+// source:, type:, tag:, author:, color:, kind:, is: (favorite, pinned or
+// suspended) and added: (with >, >=, <, <= or = and a YYYY-MM-DD date).
+// Repeated filters on the same field are OR-ed. Unbalanced quotes and
+// parentheses are tolerated.
+func ParseQuery(input string) (*SearchQuery, error) {
+	p := &queryParser{tokens: tokenize(input)}
+	root, err := p.parseOr(true)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &SearchQuery{Filters: p.filters}
+	if root == nil {
+		return q, nil
+	}
+	if group, ok := root.(*andNode); ok && len(group.pos) == 0 {
+		q.exclude = group.neg
+		return q, nil
+	}
+	q.include = root
+	return q, nil
+}
+
+// Empty reports whether the query matches nothing in particular, e.g. the
+// input was only punctuation.
+func (q *SearchQuery) Empty() bool {
+	return q.include == nil && q.exclude == nil && len(q.Filters) == 0
+}
+
+// HasCJK reports whether any search term contains Chinese, Japanese or
+// Korean text, which the unicode61 tokenizer cannot split into words.
+func (q *SearchQuery) HasCJK() bool {
+	found := false
+	walkTerms(q.include, func(t termNode) { found = found || isCJK(t.text) })
+	walkTerms(orNode{items: q.exclude}, func(t termNode) { found = found || isCJK(t.text) })
+	return found
+}
+
+func isCJK(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+func walkTerms(n queryNode, fn func(termNode)) {
+	switch n := n.(type) {
+	case termNode:
+		fn(n)
+	case *andNode:
+		for _, c := range n.pos {
+			walkTerms(c, fn)
+		}
+		for _, c := range n.neg {
+			walkTerms(c, fn)
+		}
+	case orNode:
+		for _, c := range n.items {
+			walkTerms(c, fn)
+		}
+	case nearNode:
+		for _, t := range n.terms {
+			fn(t)
+		}
+	}
+}
+
+// likeTerm is a term too short for the trigram index, matched with LIKE.
+type likeTerm struct {
+	text   string
+	negate bool
+}
+
+func isShortTerm(n queryNode, minLen int) (termNode, bool) {
+	t, ok := n.(termNode)
+	return t, ok && utf8.RuneCountInString(t.text) < minLen
+}
+
+// splitShortTerms pulls terms shorter than minLen runes out of the
+// top-level AND group so they can be matched with LIKE instead. Short terms
+// nested in OR, NEAR or parentheses cannot be expressed that way.
+func splitShortTerms(n queryNode, minLen int) (queryNode, []likeTerm, error) {
+	if n == nil || minLen == 0 {
+		return n, nil, nil
+	}
+	if t, ok := isShortTerm(n, minLen); ok {
+		return nil, []likeTerm{{text: t.text}}, nil
+	}
+
+	var likes []likeTerm
+	rest := n
+	if group, ok := n.(*andNode); ok {
+		kept := &andNode{}
+		for _, c := range group.pos {
+			if t, ok := isShortTerm(c, minLen); ok {
+				likes = append(likes, likeTerm{text: t.text})
+				continue
+			}
+			kept.pos = append(kept.pos, c)
+		}
+		for _, c := range group.neg {
+			if t, ok := isShortTerm(c, minLen); ok {
+				likes = append(likes, likeTerm{text: t.text, negate: true})
+				continue
+			}
+			kept.neg = append(kept.neg, c)
+		}
+		switch {
+		case len(kept.pos) == 0 && len(kept.neg) == 0:
+			rest = nil
+		case len(kept.pos) == 0:
+			// Only long negated terms are left; FTS5 needs something to
+			// subtract them from
+			return nil, nil, &QueryError{Msg: fmt.Sprintf("Substring search needs a positive term of at least %d characters", minLen)}
+		default:
+			rest = simplify(kept)
+		}
+	}
+
+	tooShort := false
+	walkTerms(rest, func(t termNode) {
+		tooShort = tooShort || utf8.RuneCountInString(t.text) < minLen
+	})
+	if tooShort {
+		return nil, nil, &QueryError{Msg: fmt.Sprintf("Substring search needs terms of at least %d characters inside OR, NEAR or parentheses", minLen)}
+	}
+	return rest, likes, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokPhrase
+	tokField
+	tokLParen
+	tokRParen
+	tokMinus
+	tokOr
+	tokAnd
+	tokNot
+	tokNear
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	field string
+	dist  int
+}
+
+func tokenize(input string) []token {
+	var tokens []token
+	// SQLite ends FTS query strings at a NUL, so control characters are
+	// read as spaces
+	runes := []rune(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, input))
+	i := 0
+
+	readQuoted := func() string {
+		// i points just past the opening quote; an unterminated quote runs
+		// to the end of the input
+		start := i
+		for i < len(runes) && runes[i] != '"' {
+			i++
+		}
+		text := string(runes[start:i])
+		if i < len(runes) {
+			i++
+		}
+		return text
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen})
+			i++
+		case r == '"':
+			i++
+			tokens = append(tokens, token{kind: tokPhrase, text: readQuoted()})
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != '-':
+			tokens = append(tokens, token{kind: tokMinus})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+				i++
+			}
+			word := string(runes[start:i])
+
+			if field, value, ok := strings.Cut(word, ":"); ok && isFilterField(field) {
+				if value == "" && i < len(runes) && runes[i] == '"' {
+					i++
+					value = readQuoted()
+				}
+				tokens = append(tokens, token{kind: tokField, field: strings.ToLower(field), text: value})
+				continue
+			}
+
+			switch {
+			case word == "OR":
+				tokens = append(tokens, token{kind: tokOr})
+			case word == "AND":
+				tokens = append(tokens, token{kind: tokAnd})
+			case word == "NOT":
+				tokens = append(tokens, token{kind: tokNot})
+			case word == "NEAR":
+				tokens = append(tokens, token{kind: tokNear, dist: 10})
+			case strings.HasPrefix(word, "NEAR/"):
+				if dist, err := strconv.Atoi(word[len("NEAR/"):]); err == nil && dist >= 0 {
+					tokens = append(tokens, token{kind: tokNear, dist: dist})
+					continue
+				}
+				tokens = append(tokens, token{kind: tokWord, text: word})
+			default:
+				tokens = append(tokens, token{kind: tokWord, text: word})
+			}
+		}
+	}
+	return dropUnmatchedParens(tokens)
+}
+
+// dropUnmatchedParens removes closing parentheses that were never opened,
+// so a stray ")" cannot end the query early.
+func dropUnmatchedParens(tokens []token) []token {
+	depth := 0
+	kept := tokens[:0]
+	for _, t := range tokens {
+		switch t.kind {
+		case tokLParen:
+			depth++
+		case tokRParen:
+			if depth == 0 {
+				continue
+			}
+			depth--
+		}
+		kept = append(kept, t)
+	}
+	return kept
+}
+
+func isFilterField(field string) bool {
+	for _, f := range FilterFields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryNode is a node of the parsed boolean expression.
+type queryNode interface {
+	fts() string
+}
+
+type termNode struct {
+	text   string
+	prefix bool
+}
+
+type andNode struct {
+	pos []queryNode
+	neg []queryNode
+}
+
+type orNode struct {
+	items []queryNode
+}
+
+type nearNode struct {
+	terms []termNode
+	dist  int
+}
+
+func (t termNode) fts() string {
+	s := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+	if t.prefix {
+		s += "*"
+	}
+	return s
+}
+
+func (a *andNode) fts() string {
+	parts := make([]string, len(a.pos))
+	for i, n := range a.pos {
+		parts[i] = wrapFTS(n)
+	}
+	s := strings.Join(parts, " AND ")
+	for _, n := range a.neg {
+		s += " NOT " + wrapFTS(n)
+	}
+	return s
+}
+
+func (o orNode) fts() string {
+	parts := make([]string, len(o.items))
+	for i, n := range o.items {
+		parts[i] = wrapFTS(n)
+	}
+	return strings.Join(parts, " OR ")
+}
+
+func (n nearNode) fts() string {
+	parts := make([]string, len(n.terms))
+	for i, t := range n.terms {
+		parts[i] = t.fts()
+	}
+	return fmt.Sprintf("NEAR(%s, %d)", strings.Join(parts, " "), n.dist)
+}
+
+func wrapFTS(n queryNode) string {
+	switch n.(type) {
+	case termNode, nearNode:
+		return n.fts()
+	}
+	return "(" + n.fts() + ")"
+}
+
+type queryParser struct {
+	tokens  []token
+	pos     int
+	filters []Filter
+}
+
+func (p *queryParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// parseOr parses a sequence of AND groups separated by OR. top is true for
+// the outermost expression, where a group of only negated terms is allowed.
+func (p *queryParser) parseOr(top bool) (queryNode, error) {
+	var items []queryNode
+	for {
+		group, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			items = append(items, group)
+		}
+		t := p.peek()
+		if t == nil || t.kind != tokOr {
+			break
+		}
+		p.pos++
+	}
+
+	for _, item := range items {
+		if a, ok := item.(*andNode); ok && len(a.pos) == 0 && !(top && len(items) == 1) {
+			return nil, &QueryError{Msg: "A negated term needs at least one positive term next to it"}
+		}
+	}
+
+	switch len(items) {
+	case 0:
+		return nil, nil
+	case 1:
+		return simplify(items[0]), nil
+	}
+	for i, item := range items {
+		items[i] = simplify(item)
+	}
+	return orNode{items: items}, nil
+}
+
+// parseAnd parses implicitly AND-ed terms up to an OR, a closing
+// parenthesis or the end of input.
+func (p *queryParser) parseAnd() (*andNode, error) {
+	group := &andNode{}
+	for {
+		t := p.peek()
+		if t == nil || t.kind == tokOr {
+			break
+		}
+		if t.kind == tokRParen {
+			break
+		}
+		if t.kind == tokAnd {
+			p.pos++
+			continue
+		}
+
+		negate := false
+		if t.kind == tokMinus || t.kind == tokNot {
+			negate = true
+			p.pos++
+			t = p.peek()
+			if t == nil {
+				break
+			}
+		}
+
+		if t.kind == tokField {
+			p.pos++
+			filter, err := newFilter(t.field, t.text, negate)
+			if err != nil {
+				return nil, err
+			}
+			if filter != nil {
+				p.filters = append(p.filters, *filter)
+			}
+			continue
+		}
+
+		node, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		node, err = p.parseNear(node)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			continue
+		}
+		if negate {
+			group.neg = append(group.neg, node)
+		} else {
+			group.pos = append(group.pos, node)
+		}
+	}
+
+	if len(group.pos) == 0 && len(group.neg) == 0 {
+		return nil, nil
+	}
+	return group, nil
+}
+
+// parseNear folds "a NEAR b NEAR/3 c" into a single NEAR group. FTS5 takes
+// a single distance per group, so the smallest one given is used.
+func (p *queryParser) parseNear(first queryNode) (queryNode, error) {
+	t := p.peek()
+	if t == nil || t.kind != tokNear {
+		return first, nil
+	}
+
+	start, ok := first.(termNode)
+	if !ok {
+		return nil, &QueryError{Msg: "NEAR can only join words or quoted phrases"}
+	}
+	near := nearNode{terms: []termNode{start}, dist: t.dist}
+	for t != nil && t.kind == tokNear {
+		if t.dist < near.dist {
+			near.dist = t.dist
+		}
+		p.pos++
+		next, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		term, ok := next.(termNode)
+		if !ok {
+			return nil, &QueryError{Msg: "NEAR can only join words or quoted phrases"}
+		}
+		near.terms = append(near.terms, term)
+		t = p.peek()
+	}
+	return near, nil
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, nil
+	}
+	p.pos++
+
+	switch t.kind {
+	case tokLParen:
+		inner, err := p.parseOr(false)
+		if err != nil {
+			return nil, err
+		}
+		// A missing closing parenthesis is treated as closed at the end
+		if next := p.peek(); next != nil && next.kind == tokRParen {
+			p.pos++
+		}
+		return inner, nil
+	case tokPhrase:
+		if !hasWordChars(t.text) {
+			return nil, nil
+		}
+		return termNode{text: t.text}, nil
+	case tokWord:
+		text := t.text
+		prefix := strings.HasSuffix(text, "*")
+		text = strings.TrimRight(text, "*")
+		if !hasWordChars(text) {
+			return nil, nil
+		}
+		return termNode{text: text, prefix: prefix}, nil
+	case tokNear:
+		// NEAR with nothing on its left is just the word
+		return termNode{text: "NEAR"}, nil
+	}
+	// Stray operators are ignored
+	return nil, nil
+}
+
+func simplify(n queryNode) queryNode {
+	if a, ok := n.(*andNode); ok && len(a.pos) == 1 && len(a.neg) == 0 {
+		return a.pos[0]
+	}
+	return n
+}
+
+func hasWordChars(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func newFilter(field, value string, negate bool) (*Filter, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	filter := &Filter{Field: field, Op: "=", Value: value, Negate: negate}
+
+	if field == "is" {
+		filter.Value = strings.ToLower(value)
+		if !slices.Contains(models.HighlightFlags, filter.Value) {
+			return nil, &QueryError{Msg: fmt.Sprintf("is: expects favorite, pinned or suspended, got %q", value)}
+		}
+	}
+	if field == "added" {
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, op) {
+				filter.Op = op
+				filter.Value = strings.TrimSpace(value[len(op):])
+				break
+			}
+		}
+		if _, err := time.Parse("2006-01-02", filter.Value); err != nil {
+			return nil, &QueryError{Msg: fmt.Sprintf("added: expects a date like 2025-01-31, got %q", filter.Value)}
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		input   string
+		include string
+		exclude []string
+		filters []Filter
+	}{
+		{input: "habits", include: `"habits"`},
+		{input: `"atomic habits"`, include: `"atomic habits"`},
+		{input: "habit*", include: `"habit"*`},
+		{input: "habits systems", include: `"habits" AND "systems"`},
+		{input: "habits AND systems", include: `"habits" AND "systems"`},
+		{input: "habits OR systems", include: `"habits" OR "systems"`},
+		{input: "habits -goals", include: `"habits" NOT "goals"`},
+		{input: "habits NOT goals", include: `"habits" NOT "goals"`},
+		{input: "-goals", exclude: []string{`"goals"`}},
+		{input: "habits NEAR systems", include: `NEAR("habits" "systems", 10)`},
+		{input: "habits NEAR/3 systems NEAR/5 goals", include: `NEAR("habits" "systems" "goals", 3)`},
+		{input: "(habits OR systems) goals", include: `("habits" OR "systems") AND "goals"`},
+		{input: `say "it""s"`, include: `"say" AND "it" AND "s"`},
+		{
+			input:   `source:"Atomic Habits" habits`,
+			include: `"habits"`,
+			filters: []Filter{{Field: "source", Op: "=", Value: "Atomic Habits"}},
+		},
+		{
+			input:   "habits -tag:read TAG:later",
+			include: `"habits"`,
+			filters: []Filter{{Field: "tag", Op: "=", Value: "read", Negate: true}, {Field: "tag", Op: "=", Value: "later"}},
+		},
+		{input: "added:>=2025-01-01", filters: []Filter{{Field: "added", Op: ">=", Value: "2025-01-01"}}},
+		{input: "added:2025-01-01", filters: []Filter{{Field: "added", Op: "=", Value: "2025-01-01"}}},
+		{input: "title:habits", include: `"title:habits"`},
+		// Malformed input is read as well as it can be
+		{input: `"unterminated phrase`, include: `"unterminated phrase"`},
+		{input: "habits)", include: `"habits"`},
+		{input: "(habits", include: `"habits"`},
+		{input: "NEAR habits", include: `"NEAR" AND "habits"`},
+		{input: "habits NEAR/x", include: `"habits" AND "NEAR/x"`},
+		{input: "habits OR", include: `"habits"`},
+		{input: "habits\x00goals", include: `"habits" AND "goals"`},
+		{input: `*** "" ()`},
+		{input: "source:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q): %v", tt.input, err)
+			}
+			include := ""
+			if q.include != nil {
+				include = q.include.fts()
+			}
+			if include != tt.include {
+				t.Errorf("include = %s, want %s", include, tt.include)
+			}
+			var exclude []string
+			for _, n := range q.exclude {
+				exclude = append(exclude, n.fts())
+			}
+			if !reflect.DeepEqual(exclude, tt.exclude) {
+				t.Errorf("exclude = %q, want %q", exclude, tt.exclude)
+			}
+			if !reflect.DeepEqual(q.Filters, tt.filters) {
+				t.Errorf("filters = %+v, want %+v", q.Filters, tt.filters)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, input := range []string{
+		"-goals OR habits",
+		"habits (-goals)",
+		"added:yesterday",
+		"added:>2025-13-01",
+		"(habits OR systems) NEAR goals",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseQuery(input)
+			var queryErr *QueryError
+			if !errors.As(err, &queryErr) {
+				t.Errorf("ParseQuery(%q) = %v, want a QueryError", input, err)
+			}
+		})
+	}
+}
+
+// TestSearchMalformedInput checks that no input makes a search fail with
+// anything but a QueryError, which is shown to the user instead of a 500.
+func TestSearchMalformedInput(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"Habits are the compound interest of self-improvement.",
+		"You do not rise to the level of your goals.")
+
+	inputs := []string{
+		`"`, `(`, `)`, `*`, `-`, `--`, `-"`, `NOT`, `OR`, `AND OR NOT`, `NEAR`, `NEAR/`, `a NEAR`,
+		`a NEAR/-1 b`, `foo"bar`, `^habits`, `col:habits`, `habits:`, `source:`, `added:>`,
+		`habits -`, `'`, `\`, `a*b`, `**habits`, `中文`, `ab`, `habits NEAR "" goals`, `(((`, `)))`, "\"habits\x00goals\"", "source:\"a\x00b\"",
+		`"habits" -"goals" OR`, `-(habits)`, `habits {goals}`, "habits\x00goals",
+	}
+	for _, mode := range SearchModes {
+		for _, input := range inputs {
+			_, err := search.GetSearchResults(input, SearchOptions{Mode: mode, Limit: 10})
+			var queryErr *QueryError
+			if err != nil && !errors.As(err, &queryErr) {
+				t.Errorf("%s search for %q: %v", mode, input, err)
+			}
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.")
+	importTexts(t, db, "Deep Work", "Deep work habits take practice.")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"habits", []string{"Atomic Habits", "Deep Work"}},
+		{`habits source:"deep work"`, []string{"Deep Work"}},
+		{`habits -source:"Deep Work"`, []string{"Atomic Habits"}},
+		{"(compound OR practice) -interest", []string{"Deep Work"}},
+		{"source:Nowhere habits", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, err := search.GetSearchResults(tt.query, SearchOptions{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sources []string
+			for _, result := range page.Results {
+				sources = append(sources, result.Source)
+			}
+			slices.Sort(sources)
+			if !reflect.DeepEqual(sources, tt.want) {
+				t.Errorf("sources = %q, want %q", sources, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// relatedTermCount is how many of a highlight's most distinctive terms are
+// used to look for related highlights.
+const relatedTermCount = 12
+
+type weightedTerm struct {
+	term   string
+	weight float64
+}
+
+// GetRelatedHighlights finds the highlights most similar to highlight across
+// all sources. It picks the highlight's terms with the highest TF-IDF
+// weight from the FTS vocabulary and ranks other highlights containing any
+// of them by bm25, so rarer shared terms count for more. Suspended
+// highlights are left out.
+func (search *Search) GetRelatedHighlights(highlight models.Highlight, limit int) ([]models.SearchResult, error) {
+	rowid := int64(highlight.ID)
+	terms, err := search.distinctiveTerms(rowid, relatedTermCount)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = termNode{text: t.term}.fts()
+	}
+	match := strings.Join(parts, " OR ")
+
+	rows, err := search.Query(`
+		SELECT f.rowid,
+			f.source,
+			f.source_type,
+			f.content,
+			highlight(highlights_fts, 2, ?, ?),
+			bm25(highlights_fts),
+			COALESCE(h.source_id, 0)
+		FROM highlights_fts f
+		JOIN highlights h ON h.id = f.rowid
+		WHERE f.content MATCH ? AND f.rowid != ? AND f.content != ?
+			AND f.rowid NOT IN (SELECT id FROM highlights WHERE suspended)
+		ORDER BY bm25(highlights_fts)
+		LIMIT ?`, matchStart, matchEnd, match, rowid, highlight.Content, limit)
+	if err != nil {
+		log.Println("Error querying related highlights:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var result models.SearchResult
+		var highlighted string
+		err := rows.Scan(&result.ID, &result.Source, &result.SourceType, &result.Content, &highlighted, &result.Score, &result.SourceID)
+		if err != nil {
+			log.Println("Error scanning related highlight:", err)
+			return nil, err
+		}
+		result.Highlighted = markMatches(highlighted)
+		result.Snippet = result.Highlighted
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// distinctiveTerms returns the n terms of an FTS row with the highest
+// TF-IDF weight, skipping very short and numeric terms.
+func (search *Search) distinctiveTerms(rowid int64, n int) ([]weightedTerm, error) {
+	var total int
+	if err := search.QueryRow("SELECT COUNT(*) FROM highlights_fts").Scan(&total); err != nil {
+		log.Println("Error counting FTS rows:", err)
+		return nil, err
+	}
+
+	rows, err := search.Query(`
+		SELECT i.term, COUNT(*), v.doc
+		FROM highlights_vocab_instance i
+		JOIN highlights_vocab v ON v.term = i.term
+		WHERE i.doc = ? AND i.col = 'content'
+		GROUP BY i.term`, rowid)
+	if err != nil {
+		log.Println("Error querying FTS term instances:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terms []weightedTerm
+	for rows.Next() {
+		var term string
+		var tf, df int
+		if err := rows.Scan(&term, &tf, &df); err != nil {
+			log.Println("Error scanning FTS term instance:", err)
+			return nil, err
+		}
+		if len([]rune(term)) < 3 || !strings.ContainsFunc(term, unicode.IsLetter) {
+			continue
+		}
+		// Terms found in most highlights ("the", "and") say nothing about
+		// this one
+		if df >= total || (total > 2 && df*2 > total) {
+			continue
+		}
+		idf := math.Log(float64(total) / float64(df))
+		terms = append(terms, weightedTerm{term: term, weight: float64(tf) * idf})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight != terms[j].weight {
+			return terms[i].weight > terms[j].weight
+		}
+		return terms[i].term < terms[j].term
+	})
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms, nil
+}
@@ -0,0 +1,37 @@
+package database
+
+import "testing"
+
+func TestGetRelatedHighlights(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"Compound interest works on habits the way it works on money.",
+		"Small habits are the atoms of change.")
+	importTexts(t, db, "The Psychology of Money",
+		"Compound interest is the most powerful force in investing money.",
+		"Saving money is a habit of mind.")
+	importTexts(t, db, "Walden", "Nothing in the woods needs a ledger.")
+
+	highlight := findHighlight(t, db, "Atomic Habits", "Compound interest works on habits the way it works on money.")
+	related, err := search.GetRelatedHighlights(highlight, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(related) == 0 {
+		t.Fatal("found no related highlights")
+	}
+	if related[0].Content != "Compound interest is the most powerful force in investing money." {
+		t.Errorf("most related is %q, want the one sharing compound interest", related[0].Content)
+	}
+	if related[0].ID == 0 || related[0].Source != "The Psychology of Money" {
+		t.Errorf("most related has id %d and source %q", related[0].ID, related[0].Source)
+	}
+	for _, result := range related {
+		if result.Content == highlight.Content {
+			t.Error("a highlight is related to itself")
+		}
+		if result.Source == "Walden" {
+			t.Error("a highlight sharing no terms is related")
+		}
+	}
+}
@@ -2,8 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"highlights-anki/internal/models"
+	"html/template"
 	"log"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
@@ -12,6 +15,63 @@ type Search struct {
 	*sql.DB
 }
 
+// ftsTables are the FTS5 indexes over the highlights table. Both are
+// external-content tables: they store no text of their own, their rowid is
+// highlights.id, and the triggers below keep them in step with every
+// insert, update and delete on highlights. Notes are indexed alongside the
+// content so searches find a highlight by what was written about it.
+var ftsTables = map[string]string{
+	"highlights_fts": `
+	CREATE VIRTUAL TABLE highlights_fts USING fts5(
+    source, source_type UNINDEXED, content, note,
+    content='highlights', content_rowid='id', tokenize='porter unicode61' );`,
+
+	// Used for substring and CJK searches, which word tokenizers cannot
+	// handle.
+	"highlights_trigram": `
+	CREATE VIRTUAL TABLE highlights_trigram USING fts5(
+    source, source_type UNINDEXED, content, note,
+    content='highlights', content_rowid='id', tokenize='trigram' );`,
+}
+
+// The triggers are recreated on every start so that changes to them reach
+// existing databases. Semantic vectors are built from the content alone, so
+// editing only the note keeps them.
+const createSearchTriggersQuery = `
+	DROP TRIGGER IF EXISTS highlights_fts_insert;
+	DROP TRIGGER IF EXISTS highlights_fts_delete;
+	DROP TRIGGER IF EXISTS highlights_fts_update;
+
+	CREATE TRIGGER highlights_fts_insert AFTER INSERT ON highlights BEGIN
+		INSERT INTO highlights_fts (rowid, source, source_type, content, note)
+		VALUES (new.id, new.source, new.source_type, new.content, new.note);
+		INSERT INTO highlights_trigram (rowid, source, source_type, content, note)
+		VALUES (new.id, new.source, new.source_type, new.content, new.note);
+	END;
+
+	CREATE TRIGGER highlights_fts_delete AFTER DELETE ON highlights BEGIN
+		INSERT INTO highlights_fts (highlights_fts, rowid, source, source_type, content, note)
+		VALUES ('delete', old.id, old.source, old.source_type, old.content, old.note);
+		INSERT INTO highlights_trigram (highlights_trigram, rowid, source, source_type, content, note)
+		VALUES ('delete', old.id, old.source, old.source_type, old.content, old.note);
+		DELETE FROM semantic_vectors WHERE fts_rowid = old.id;
+	END;
+
+	CREATE TRIGGER highlights_fts_update AFTER UPDATE OF source, source_type, content, note ON highlights BEGIN
+		INSERT INTO highlights_fts (highlights_fts, rowid, source, source_type, content, note)
+		VALUES ('delete', old.id, old.source, old.source_type, old.content, old.note);
+		INSERT INTO highlights_trigram (highlights_trigram, rowid, source, source_type, content, note)
+		VALUES ('delete', old.id, old.source, old.source_type, old.content, old.note);
+		INSERT INTO highlights_fts (rowid, source, source_type, content, note)
+		VALUES (new.id, new.source, new.source_type, new.content, new.note);
+		INSERT INTO highlights_trigram (rowid, source, source_type, content, note)
+		VALUES (new.id, new.source, new.source_type, new.content, new.note);
+		DELETE FROM semantic_vectors WHERE fts_rowid = old.id
+			AND (new.source IS NOT old.source OR new.source_type IS NOT old.source_type OR new.content IS NOT old.content);
+	END;`
+
+// InitSearch opens the search indexes. The highlights table must already
+// exist, so call it after InitDb.
 func InitSearch(dbUri string) (*Search, error) {
 	log.Println("Initializing search database at:", dbUri)
 	db, err := sql.Open("sqlite", dbUri)
@@ -21,77 +81,533 @@ func InitSearch(dbUri string) (*Search, error) {
 		return nil, err
 	}
 
-	createSearchTableQuery := `
-	CREATE VIRTUAL TABLE IF NOT EXISTS highlights_fts USING fts5(
-    title, content, tokenize='porter unicode61' );`
+	search := &Search{db}
+	rebuild := false
+	for name, createQuery := range ftsTables {
+		created, err := search.ensureExternalContentTable(name, createQuery)
+		if err != nil {
+			log.Println("Error creating FTS table:", name, err)
+			return nil, err
+		}
+		rebuild = rebuild || created
+	}
+
+	// Term and document counts of highlights_fts, for completions and
+	// spelling suggestions.
+	createVocabTableQuery := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS highlights_vocab USING fts5vocab(
+    highlights_fts, row );`
 
-	_, err = db.Exec(createSearchTableQuery)
+	_, err = db.Exec(createVocabTableQuery)
 	if err != nil {
-		log.Println("Error creating FTS table:", err)
+		log.Println("Error creating FTS vocabulary table:", err)
 		return nil, err
 	}
-	return &Search{db}, nil
-}
 
-func (search *Search) InsertToFTS(highlights []models.Highlight, title string) error {
+	// Per-row term occurrences of highlights_fts, for related highlights.
+	createVocabInstanceTableQuery := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS highlights_vocab_instance USING fts5vocab(
+    highlights_fts, instance );`
 
-	log.Println("Inserting highlights into FTS table with title: and size: ", title, len(highlights))
-	tx, err := search.Begin()
+	_, err = db.Exec(createVocabInstanceTableQuery)
+	if err != nil {
+		log.Println("Error creating FTS vocabulary instance table:", err)
+		return nil, err
+	}
+
+	// LSA term and highlight vectors for semantic search, built by
+	// BuildSemanticIndex. fts_rowid is the highlight id.
+	createSemanticTablesQuery := `
+	CREATE TABLE IF NOT EXISTS semantic_terms (
+		term TEXT PRIMARY KEY,
+		idf REAL NOT NULL,
+		vector BLOB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS semantic_vectors (
+		fts_rowid INTEGER PRIMARY KEY,
+		vector BLOB NOT NULL
+	);`
 
+	_, err = db.Exec(createSemanticTablesQuery)
 	if err != nil {
-		log.Println("Error beginning FTS transaction:", err)
-		return err
+		log.Println("Error creating semantic tables:", err)
+		return nil, err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO highlights_fts (title, content) VALUES (?, ?)")
+	// In a transaction, so no insert can slip between drop and create
+	tx, err := db.Begin()
 	if err != nil {
-		log.Println("Error preparing FTS statement:", err)
-		return err
+		return nil, err
 	}
+	_, err = tx.Exec(createSearchTriggersQuery)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Error creating FTS triggers:", err)
+		return nil, err
+	}
+
+	if rebuild {
+		if _, err := search.Reindex(); err != nil {
+			return nil, err
+		}
+	}
+	return search, nil
+}
 
-	defer stmt.Close()
+// ensureExternalContentTable creates an FTS table from createQuery unless
+// it already exists with that definition. A table with another definition,
+// such as one from before the index was tied to the highlights table or
+// before notes were indexed, is dropped and recreated. It reports whether
+// the table was (re)created and so needs a rebuild.
+func (search *Search) ensureExternalContentTable(name, createQuery string) (bool, error) {
+	var existing string
+	err := search.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&existing)
+	if err == nil && sameDefinition(existing, createQuery) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	if err == nil {
+		log.Println("Migrating FTS table to new definition:", name)
+		if _, err := search.Exec("DROP TABLE " + name); err != nil {
+			return false, err
+		}
+	}
+	if _, err := search.Exec(createQuery); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sameDefinition compares a table's stored CREATE statement with a create
+// query, ignoring whitespace and the trailing semicolon that sqlite_master
+// does not keep.
+func sameDefinition(stored, createQuery string) bool {
+	normalize := func(s string) string {
+		return strings.Join(strings.Fields(strings.TrimSuffix(strings.TrimSpace(s), ";")), "")
+	}
+	return normalize(stored) == normalize(createQuery)
+}
 
-	for _, highlight := range highlights {
-		_, err = stmt.Exec(title, highlight.Content)
+// Reindex rebuilds both FTS indexes from the highlights table and drops the
+// stored semantic vectors so they are recomputed on the next semantic
+// search. It returns the number of highlights indexed.
+func (search *Search) Reindex() (int, error) {
+	log.Println("Rebuilding FTS indexes from the highlights table")
+	tx, err := search.Begin()
+	if err != nil {
+		log.Println("Error beginning reindex transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, name := range []string{"highlights_fts", "highlights_trigram"} {
+		_, err := tx.Exec("INSERT INTO " + name + " (" + name + ") VALUES ('rebuild')")
 		if err != nil {
-			log.Println("Error inserting highlight into FTS:", err)
-			return err
+			log.Println("Error rebuilding FTS table:", name, err)
+			return 0, err
 		}
 	}
+	if _, err := tx.Exec("DELETE FROM semantic_vectors"); err != nil {
+		log.Println("Error clearing semantic vectors:", err)
+		return 0, err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM highlights").Scan(&count); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing reindex transaction:", err)
+		return 0, err
+	}
+	log.Println("Rebuilt FTS indexes for highlights:", count)
+	return count, nil
+}
+
+// Markers passed to snippet() and highlight(). They cannot occur in
+// highlight text, so the content can be HTML-escaped before they are
+// swapped for <mark> tags.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// Search modes choose which FTS index answers a query.
+const (
+	// SearchModeAuto uses the word index, switching to the substring index
+	// for CJK queries and for queries the word index finds nothing for.
+	SearchModeAuto = "auto"
+	// SearchModeWords matches stemmed whole words (porter unicode61).
+	SearchModeWords = "words"
+	// SearchModeSubstring matches any part of a word (trigram).
+	SearchModeSubstring = "substring"
+	// SearchModeSemantic ranks by LSA vector similarity blended with bm25.
+	SearchModeSemantic = "semantic"
+)
+
+var SearchModes = []string{SearchModeAuto, SearchModeWords, SearchModeSubstring, SearchModeSemantic}
+
+type SearchOptions struct {
+	Mode   string
+	Limit  int
+	Offset int
+}
+
+// ftsIndex describes one of the FTS tables a query can run against.
+type ftsIndex struct {
+	table string
+	// snippetTokens is the snippet() window; trigram tokens are single
+	// characters, so it needs a much wider window than the word index.
+	snippetTokens int
+	// minTermLen is the shortest term the index can match. Shorter terms
+	// fall back to LIKE.
+	minTermLen int
+}
+
+var (
+	wordIndex      = ftsIndex{table: "highlights_fts", snippetTokens: 32, minTermLen: 0}
+	substringIndex = ftsIndex{table: "highlights_trigram", snippetTokens: 96, minTermLen: 3}
+)
+
+// GetSearchResults returns one page of results for query, ranked by bm25,
+// together with the total hit count and per-source facet counts.
+func (search *Search) GetSearchResults(query string, opts SearchOptions) (*models.SearchPage, error) {
+	log.Println("Searching FTS table with query:", query, "options:", opts)
+	parsed, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Empty() {
+		return &models.SearchPage{Query: query, Mode: opts.Mode, Offset: opts.Offset, Limit: opts.Limit, Results: []models.SearchResult{}}, nil
+	}
+
+	var page *models.SearchPage
+	switch opts.Mode {
+	case SearchModeWords:
+		page, err = search.searchIndex(wordIndex, SearchModeWords, query, parsed, opts)
+	case SearchModeSubstring:
+		page, err = search.searchIndex(substringIndex, SearchModeSubstring, query, parsed, opts)
+	case SearchModeSemantic:
+		page, err = search.searchSemantic(query, parsed, opts)
+	case SearchModeAuto, "":
+		page, err = search.searchAuto(query, parsed, opts)
+	default:
+		return nil, &QueryError{Msg: "Unknown search mode: " + opts.Mode}
+	}
+	if err != nil || page.Total > 0 || opts.Offset > 0 {
+		return page, err
+	}
 
-	err = tx.Commit()
+	page.Suggestion, err = search.GetSpellingSuggestion(query)
 	if err != nil {
-		log.Println("Error committing FTS transaction:", err)
-		return err
+		// A failed suggestion should not hide the (empty) results
+		log.Println("Error computing spelling suggestion:", err)
 	}
+	return page, nil
+}
 
-	log.Println("Successfully inserted highlights into FTS table")
-	return nil
+func (search *Search) searchAuto(query string, parsed *SearchQuery, opts SearchOptions) (*models.SearchPage, error) {
+	if parsed.HasCJK() {
+		return search.searchIndex(substringIndex, SearchModeSubstring, query, parsed, opts)
+	}
+	page, err := search.searchIndex(wordIndex, SearchModeWords, query, parsed, opts)
+	if err != nil || page.Total > 0 {
+		return page, err
+	}
+
+	// Nothing matched whole words; try again as substrings. Terms too short
+	// for the trigram index are an error there, but not worth reporting as
+	// one when the user never asked for substring search.
+	fallback, err := search.searchIndex(substringIndex, SearchModeSubstring, query, parsed, opts)
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return page, nil
+	}
+	return fallback, err
 }
 
-func (search *Search) GetSearchResults(query string, limit int) ([]models.Highlight, error) {
-	log.Println("Searching FTS table with query:", query)
-	rows, err := search.Query("SELECT title, content FROM highlights_fts WHERE content MATCH ? LIMIT ?", query, limit)
+func (search *Search) searchIndex(index ftsIndex, mode string, query string, parsed *SearchQuery, opts SearchOptions) (*models.SearchPage, error) {
+	page := &models.SearchPage{Query: query, Mode: mode, Offset: opts.Offset, Limit: opts.Limit, Results: []models.SearchResult{}}
+
+	where, whereArgs, likes, err := parsed.where(index)
+	if err != nil {
+		return nil, err
+	}
+
+	err = search.QueryRow("SELECT COUNT(*) FROM "+index.table+" f WHERE "+where, whereArgs...).Scan(&page.Total)
+	if err != nil {
+		log.Println("Error counting FTS results:", err)
+		return nil, err
+	}
+	if page.Total == 0 {
+		return page, nil
+	}
+
+	page.Facets, err = search.getSourceFacets(index, where, whereArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{matchStart, matchEnd, index.snippetTokens, matchStart, matchEnd, matchStart, matchEnd}
+	args = append(args, whereArgs...)
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := search.Query(`
+		SELECT f.rowid,
+			f.source,
+			f.source_type,
+			f.content,
+			snippet(`+index.table+`, 2, ?, ?, '…', ?),
+			highlight(`+index.table+`, 2, ?, ?),
+			highlight(`+index.table+`, 3, ?, ?),
+			bm25(`+index.table+`),
+			h.note, COALESCE(h.source_id, 0), h.color, h.favorite, h.pinned, h.suspended
+		FROM `+index.table+` f
+		JOIN highlights h ON h.id = f.rowid
+		WHERE `+where+`
+		ORDER BY bm25(`+index.table+`), f.rowid
+		LIMIT ? OFFSET ?`, args...)
 	if err != nil {
 		log.Println("Error querying FTS table:", err)
 		return nil, err
 	}
 
 	defer rows.Close()
-	var results []models.Highlight
 
 	for rows.Next() {
-		var title, content string
-		err := rows.Scan(&title, &content)
+		var result models.SearchResult
+		var snippet, highlighted string
+		var noteHighlighted, note, color sql.NullString
+		err := rows.Scan(&result.ID, &result.Source, &result.SourceType, &result.Content, &snippet, &highlighted, &noteHighlighted, &result.Score,
+			&note, &result.SourceID, &color, &result.Favorite, &result.Pinned, &result.Suspended)
 		if err != nil {
 			log.Println("Error scanning FTS result row:", err)
 			return nil, err
 		}
-		highlight := models.Highlight{
-			Source:  title,
-			Content: content,
+		result.Snippet = markMatches(markLikeTerms(snippet, likes))
+		result.Highlighted = markMatches(markLikeTerms(highlighted, likes))
+		result.Note = note.String
+		result.Color = color.String
+		if noteHighlighted.String != "" {
+			result.NoteHighlighted = markMatches(markLikeTerms(noteHighlighted.String, likes))
+		}
+		page.Results = append(page.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if next := opts.Offset + len(page.Results); next < page.Total {
+		page.NextOffset = next
+	}
+	return page, nil
+}
+
+func (search *Search) getSourceFacets(index ftsIndex, where string, args []any) ([]models.SourceFacet, error) {
+	rows, err := search.Query(`
+		SELECT COALESCE(h.source_id, 0), f.source, f.source_type, COUNT(*)
+		FROM `+index.table+` f
+		JOIN highlights h ON h.id = f.rowid
+		WHERE `+where+`
+		GROUP BY h.source_id
+		ORDER BY COUNT(*) DESC, f.source, h.source_id`, args...)
+	if err != nil {
+		log.Println("Error querying FTS facets:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facets []models.SourceFacet
+	for rows.Next() {
+		var facet models.SourceFacet
+		if err := rows.Scan(&facet.SourceID, &facet.Source, &facet.SourceType, &facet.Count); err != nil {
+			log.Println("Error scanning FTS facet row:", err)
+			return nil, err
+		}
+		facets = append(facets, facet)
+	}
+	return facets, rows.Err()
+}
+
+// markMatches escapes FTS output for HTML and replaces the match markers
+// with <mark> tags.
+func markMatches(text string) template.HTML {
+	escaped := template.HTMLEscapeString(text)
+	escaped = strings.ReplaceAll(escaped, matchStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, matchEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// markLikeTerms wraps the positive LIKE terms in text with match markers,
+// since snippet() and highlight() only know about the FTS terms.
+func markLikeTerms(text string, likes []likeTerm) string {
+	for _, like := range likes {
+		if like.negate {
+			continue
+		}
+		text = markFold(text, like.text)
+	}
+	return text
+}
+
+// markFold marks every case-insensitive occurrence of term in text.
+func markFold(text, term string) string {
+	lower := strings.ToLower(text)
+	needle := strings.ToLower(term)
+	if len(lower) != len(text) || needle == "" {
+		// Lowercasing changed byte offsets; fall back to exact matches
+		return strings.ReplaceAll(text, term, matchStart+term+matchEnd)
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, needle)
+		if i < 0 {
+			break
+		}
+		b.WriteString(text[:i])
+		b.WriteString(matchStart + text[i:i+len(needle)] + matchEnd)
+		text, lower = text[i+len(needle):], lower[i+len(needle):]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// escapeLike escapes LIKE wildcards so term matches literally with
+// ESCAPE '\'.
+func escapeLike(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + r.Replace(term) + "%"
+}
+
+// where builds the SQL condition for a parsed query over the given FTS
+// index aliased as f. Filters on the same field are OR-ed, negated filters
+// and different fields are AND-ed, and suspended highlights are left out
+// unless asked for. It also returns the terms that were matched with LIKE
+// because they are too short for the index.
+func (q *SearchQuery) where(index ftsIndex) (string, []any, []likeTerm, error) {
+	var conds []string
+	var args []any
+
+	include, likes, err := splitShortTerms(q.include, index.minTermLen)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if include != nil {
+		conds = append(conds, "f."+index.table+" MATCH ?")
+		args = append(args, matchText(include.fts()))
+	}
+
+	var exclude []queryNode
+	for _, n := range q.exclude {
+		if t, ok := isShortTerm(n, index.minTermLen); ok {
+			likes = append(likes, likeTerm{text: t.text, negate: true})
+			continue
+		}
+		exclude = append(exclude, n)
+	}
+	if len(exclude) > 0 {
+		rest, _, err := splitShortTerms(orNode{items: exclude}, index.minTermLen)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		conds = append(conds, "f.rowid NOT IN (SELECT rowid FROM "+index.table+" WHERE "+index.table+" MATCH ?)")
+		args = append(args, matchText(rest.fts()))
+	}
+
+	for _, like := range likes {
+		cond := `(f.content LIKE ? ESCAPE '\' OR IFNULL(f.note, '') LIKE ? ESCAPE '\')`
+		if like.negate {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+		args = append(args, escapeLike(like.text), escapeLike(like.text))
+	}
+
+	var fields []string
+	grouped := map[string][]Filter{}
+	for _, filter := range q.Filters {
+		key := filter.Field
+		if filter.Negate {
+			key = "-" + key
+		}
+		if _, ok := grouped[key]; !ok {
+			fields = append(fields, key)
+		}
+		grouped[key] = append(grouped[key], filter)
+	}
+
+	for _, key := range fields {
+		var alternatives []string
+		for _, filter := range grouped[key] {
+			cond, condArgs, err := filterCondition(filter)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			alternatives = append(alternatives, cond)
+			args = append(args, condArgs...)
+		}
+		cond := "(" + strings.Join(alternatives, " OR ") + ")"
+		if strings.HasPrefix(key, "-") {
+			cond = "NOT " + cond
 		}
-		results = append(results, highlight)
+		conds = append(conds, cond)
+	}
+
+	if !q.wantsSuspended() {
+		conds = append(conds, "f.rowid NOT IN (SELECT id FROM highlights WHERE suspended)")
+	}
+
+	if len(conds) == 0 {
+		return "1", nil, likes, nil
+	}
+	return strings.Join(conds, " AND "), args, likes, nil
+}
+
+// wantsSuspended reports whether the query asks for suspended highlights
+// with is:suspended; they are left out of searches otherwise.
+func (q *SearchQuery) wantsSuspended() bool {
+	for _, filter := range q.Filters {
+		if filter.Field == "is" && filter.Value == "suspended" && !filter.Negate {
+			return true
+		}
+	}
+	return false
+}
+
+// matchText limits an FTS query to the highlight text and its note, so
+// that words in a source's name do not match every highlight of it.
+func matchText(fts string) string {
+	return "{content note} : (" + fts + ")"
+}
+
+func filterCondition(filter Filter) (string, []any, error) {
+	switch filter.Field {
+	case "source":
+		return "f.source = ? COLLATE NOCASE", []any{filter.Value}, nil
+	case "type":
+		return "f.source_type = ? COLLATE NOCASE", []any{filter.Value}, nil
+	case "tag":
+		return "f.rowid IN (" + taggedHighlightIDs + ")", []any{filter.Value, filter.Value}, nil
+	case "author":
+		return "f.rowid IN (" + authorNameHighlightIDs + ")", []any{filter.Value}, nil
+	case "color":
+		return "f.rowid IN (SELECT id FROM highlights WHERE color = ? COLLATE NOCASE)", []any{filter.Value}, nil
+	case "kind":
+		return "f.rowid IN (SELECT id FROM highlights WHERE COALESCE(kind, 'quote') = ? COLLATE NOCASE)", []any{filter.Value}, nil
+	case "is":
+		// newFilter has checked that Value is a flag column
+		return "f.rowid IN (SELECT id FROM highlights WHERE " + filter.Value + ")", nil, nil
+	case "added":
+		// newFilter has checked Op and that Value is a date
+		return "f.rowid IN (SELECT id FROM highlights WHERE date(created_at, 'localtime') " + filter.Op + " ?)", []any{filter.Value}, nil
 	}
-	return results, nil
+	return "", nil, &QueryError{Msg: filter.Field + ": filters are not supported yet"}
 }
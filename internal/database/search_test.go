@@ -0,0 +1,173 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSearchPagination(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"Habits are the compound interest of self-improvement.",
+		"Good habits make time your ally.",
+		"Every habit starts small, and habits add up.")
+	importTexts(t, db, "Deep Work", "Deep work habits take practice.")
+	importTexts(t, db, "Walden", "Nothing here matches.")
+
+	var seen []string
+	for offset, pages := 0, 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("pagination did not end")
+		}
+		page, err := search.GetSearchResults("habits", SearchOptions{Mode: SearchModeWords, Limit: 3, Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 4 {
+			t.Errorf("Total = %d, want 4", page.Total)
+		}
+		want := []models.SourceFacet{
+			{SourceID: sourceID(t, db, "Atomic Habits"), Source: "Atomic Habits", SourceType: "book", Count: 3},
+			{SourceID: sourceID(t, db, "Deep Work"), Source: "Deep Work", SourceType: "book", Count: 1},
+		}
+		if !reflect.DeepEqual(page.Facets, want) {
+			t.Errorf("Facets = %+v, want %+v", page.Facets, want)
+		}
+		for _, result := range page.Results {
+			seen = append(seen, result.Content)
+		}
+		if !page.HasMore() {
+			break
+		}
+		offset = page.NextOffset
+	}
+	if len(seen) != 4 {
+		t.Errorf("pages held %d results, want 4: %q", len(seen), seen)
+	}
+	for i := range seen {
+		for j := range i {
+			if seen[i] == seen[j] {
+				t.Errorf("%q is on two pages", seen[i])
+			}
+		}
+	}
+
+	page, err := search.GetSearchResults("habits", SearchOptions{Mode: SearchModeWords, Limit: 3, Offset: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 0 || page.HasMore() || page.Total != 4 {
+		t.Errorf("past the end got %d results, HasMore %t and Total %d", len(page.Results), page.HasMore(), page.Total)
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.")
+	importTexts(t, db, "论语", "学而时习之，不亦说乎")
+
+	tests := []struct {
+		query, mode string
+		wantMode    string
+		want        int
+	}{
+		{"habits", SearchModeWords, SearchModeWords, 1},
+		{"abit", SearchModeWords, SearchModeWords, 0},
+		{"abit", SearchModeSubstring, SearchModeSubstring, 1},
+		// Auto falls back to substrings when no whole word matches
+		{"abit", SearchModeAuto, SearchModeSubstring, 1},
+		{"habits", SearchModeAuto, SearchModeWords, 1},
+		{"时习", SearchModeAuto, SearchModeSubstring, 1},
+		{"improve -compound", SearchModeSubstring, SearchModeSubstring, 0},
+		{`"self-improvement"`, SearchModeSubstring, SearchModeSubstring, 1},
+		{"nowhere", SearchModeAuto, SearchModeSubstring, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.query, func(t *testing.T) {
+			page, err := search.GetSearchResults(tt.query, SearchOptions{Mode: tt.mode, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != tt.want || page.Mode != tt.wantMode {
+				t.Errorf("found %d in %s mode, want %d in %s mode", page.Total, page.Mode, tt.want, tt.wantMode)
+			}
+		})
+	}
+
+	// Terms shorter than a trigram are matched too
+	page, err := search.GetSearchResults("时", SearchOptions{Mode: SearchModeSubstring, Limit: 10})
+	if err != nil || page.Total != 1 {
+		t.Errorf("one character search found %v, %v; want 1", page, err)
+	}
+}
+
+func TestSearchFollowsHighlights(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.")
+
+	count := func(query string) int {
+		t.Helper()
+		total := 0
+		for _, mode := range []string{SearchModeWords, SearchModeSubstring} {
+			page, err := search.GetSearchResults(query, SearchOptions{Mode: mode, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += page.Total
+		}
+		return total
+	}
+	if n := count("compound"); n != 2 {
+		t.Fatalf("found %d before any change, want 1 per index", n)
+	}
+
+	if _, err := db.Exec("UPDATE highlights SET content = 'Systems beat goals.'"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("compound"); n != 0 {
+		t.Errorf("found the old text %d times after an update", n)
+	}
+	if n := count("systems"); n != 2 {
+		t.Errorf("found the new text %d times after an update, want 2", n)
+	}
+
+	if _, err := db.Exec("DELETE FROM highlights"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("systems"); n != 0 {
+		t.Errorf("found a deleted highlight %d times", n)
+	}
+}
+
+func TestInitSearchReplacesStandaloneIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "highlights.db")
+	db, err := InitDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// An index from before it was tied to the highlights table
+	_, err = db.Exec(`CREATE VIRTUAL TABLE highlights_fts USING fts5(title, content, tokenize='porter unicode61');
+		INSERT INTO highlights_fts (title, content) VALUES ('Gone', 'A stale row.')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importTexts(t, db, "Atomic Habits", "Habits are the compound interest of self-improvement.")
+
+	search, err := InitSearch(path)
+	if err != nil {
+		t.Fatalf("InitSearch: %v", err)
+	}
+	defer search.Close()
+	for query, want := range map[string]int{"compound": 1, "stale": 0} {
+		page, err := search.GetSearchResults(query, SearchOptions{Mode: SearchModeWords, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != want {
+			t.Errorf("found %q %d times, want %d", query, page.Total, want)
+		}
+	}
+}
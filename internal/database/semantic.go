@@ -0,0 +1,379 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"highlights-anki/internal/models"
+	"highlights-anki/internal/semantic"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultSemanticDims is the number of LSA dimensions kept by default.
+	DefaultSemanticDims = 100
+	// semanticWeight is the share of the final score that comes from
+	// vector similarity; the rest comes from the normalized bm25 score.
+	semanticWeight = 0.7
+	// minSimilarity is the cosine similarity below which a highlight with
+	// no keyword match is not returned at all.
+	minSimilarity = 0.3
+)
+
+// BuildSemanticIndex trains an LSA model over every highlight in the FTS
+// table and stores the term vectors and one vector per highlight,
+// replacing any previous model. Highlights added later are folded into the
+// existing model at query time, but only a rebuild picks up new words.
+func (search *Search) BuildSemanticIndex(dims int) (int, error) {
+	rows, err := search.Query("SELECT rowid, content FROM highlights_fts ORDER BY rowid")
+	if err != nil {
+		log.Println("Error reading FTS table for semantic index:", err)
+		return 0, err
+	}
+	var rowids []int64
+	var texts []string
+	for rows.Next() {
+		var rowid int64
+		var content string
+		if err := rows.Scan(&rowid, &content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rowids = append(rowids, rowid)
+		texts = append(texts, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	log.Println("Training semantic model over highlights:", len(texts))
+	model := semantic.Train(texts, dims)
+	if model == nil {
+		return 0, &QueryError{Msg: "Not enough highlights with shared words to build a semantic index"}
+	}
+
+	tx, err := search.Begin()
+	if err != nil {
+		log.Println("Error beginning semantic index transaction:", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM semantic_terms"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM semantic_vectors"); err != nil {
+		return 0, err
+	}
+
+	termStmt, err := tx.Prepare("INSERT INTO semantic_terms (term, idf, vector) VALUES (?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer termStmt.Close()
+	for term, i := range model.Terms {
+		if _, err := termStmt.Exec(term, model.IDF[i], encodeVector(model.TermVectors[i])); err != nil {
+			log.Println("Error inserting semantic term:", err)
+			return 0, err
+		}
+	}
+
+	vectorStmt, err := tx.Prepare("INSERT INTO semantic_vectors (fts_rowid, vector) VALUES (?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer vectorStmt.Close()
+	count := 0
+	for i, text := range texts {
+		// Highlights with no known words get an empty vector so they are
+		// not re-embedded on every search
+		vec := model.Embed(text)
+		if _, err := vectorStmt.Exec(rowids[i], encodeVector(vec)); err != nil {
+			log.Println("Error inserting semantic vector:", err)
+			return 0, err
+		}
+		if vec != nil {
+			count++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Error committing semantic index:", err)
+		return 0, err
+	}
+	log.Printf("Stored semantic model with %d terms and %d dimensions\n", len(model.Terms), model.Dims)
+	return count, nil
+}
+
+// loadSemanticModel reads the stored LSA model, or returns nil if none has
+// been built.
+func (search *Search) loadSemanticModel() (*semantic.Model, error) {
+	rows, err := search.Query("SELECT term, idf, vector FROM semantic_terms")
+	if err != nil {
+		log.Println("Error reading semantic model:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	model := &semantic.Model{Terms: map[string]int{}}
+	for rows.Next() {
+		var term string
+		var idf float64
+		var blob []byte
+		if err := rows.Scan(&term, &idf, &blob); err != nil {
+			return nil, err
+		}
+		vec := decodeVector(blob)
+		model.Terms[term] = len(model.IDF)
+		model.IDF = append(model.IDF, idf)
+		model.TermVectors = append(model.TermVectors, vec)
+		model.Dims = len(vec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(model.Terms) == 0 {
+		return nil, nil
+	}
+	return model, nil
+}
+
+// foldInMissingVectors embeds FTS rows that were added after the model was
+// built and drops vectors of rows that are gone.
+func (search *Search) foldInMissingVectors(model *semantic.Model) error {
+	_, err := search.Exec("DELETE FROM semantic_vectors WHERE fts_rowid NOT IN (SELECT rowid FROM highlights_fts)")
+	if err != nil {
+		return err
+	}
+
+	rows, err := search.Query(`
+		SELECT rowid, content FROM highlights_fts
+		WHERE rowid NOT IN (SELECT fts_rowid FROM semantic_vectors)`)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		rowid int64
+		vec   []float32
+	}
+	var missing []pending
+	for rows.Next() {
+		var p pending
+		var content string
+		if err := rows.Scan(&p.rowid, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		p.vec = model.Embed(content)
+		missing = append(missing, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(missing) == 0 {
+		return err
+	}
+
+	log.Println("Folding new highlights into semantic index:", len(missing))
+	tx, err := search.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range missing {
+		if _, err := tx.Exec("INSERT INTO semantic_vectors (fts_rowid, vector) VALUES (?, ?)", p.rowid, encodeVector(p.vec)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type semanticHit struct {
+	rowid     int64
+	highlight models.Highlight
+	score     float64
+}
+
+// searchSemantic ranks highlights by a blend of LSA cosine similarity to
+// the query and bm25 keyword relevance, so that highlights expressing the
+// same idea in different words are found alongside exact matches.
+func (search *Search) searchSemantic(query string, parsed *SearchQuery, opts SearchOptions) (*models.SearchPage, error) {
+	page := &models.SearchPage{Query: query, Mode: SearchModeSemantic, Offset: opts.Offset, Limit: opts.Limit, Results: []models.SearchResult{}}
+
+	model, err := search.loadSemanticModel()
+	if err != nil {
+		return nil, err
+	}
+	if model == nil {
+		return nil, &QueryError{Msg: "Semantic search needs an index first: run `go run ./operations embed`"}
+	}
+	if err := search.foldInMissingVectors(model); err != nil {
+		log.Println("Error folding new highlights into semantic index:", err)
+		return nil, err
+	}
+
+	var words []string
+	walkTerms(parsed.include, func(t termNode) { words = append(words, t.text) })
+	queryVec := model.Embed(strings.Join(words, " "))
+
+	keywordScores, err := search.keywordScores(words)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filters and negated terms still apply, only the positive terms are
+	// scored differently
+	where, whereArgs, _, err := (&SearchQuery{Filters: parsed.Filters, exclude: parsed.exclude}).where(wordIndex)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := search.Query(`
+		SELECT f.rowid, COALESCE(h.source_id, 0), f.source, f.source_type, f.content, v.vector,
+			h.note, h.color, h.favorite, h.pinned, h.suspended
+		FROM highlights_fts f
+		JOIN semantic_vectors v ON v.fts_rowid = f.rowid
+		JOIN highlights h ON h.id = f.rowid
+		WHERE `+where, whereArgs...)
+	if err != nil {
+		log.Println("Error querying semantic vectors:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []semanticHit
+	for rows.Next() {
+		var hit semanticHit
+		var blob []byte
+		var note, color sql.NullString
+		h := &hit.highlight
+		err := rows.Scan(&hit.rowid, &h.SourceID, &h.Source, &h.SourceType, &h.Content, &blob,
+			&note, &color, &h.Favorite, &h.Pinned, &h.Suspended)
+		if err != nil {
+			log.Println("Error scanning semantic vector row:", err)
+			return nil, err
+		}
+		similarity := 0.0
+		if queryVec != nil {
+			similarity = semantic.Cosine(queryVec, decodeVector(blob))
+		}
+		h.ID = int(hit.rowid)
+		h.Note = note.String
+		h.Color = color.String
+		keyword := keywordScores[hit.rowid]
+		if similarity < minSimilarity && keyword == 0 {
+			continue
+		}
+		hit.score = semanticWeight*similarity + (1-semanticWeight)*keyword
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].rowid < hits[j].rowid
+	})
+
+	page.Total = len(hits)
+	facets := map[int]*models.SourceFacet{}
+	for _, hit := range hits {
+		h := hit.highlight
+		facet, ok := facets[h.SourceID]
+		if !ok {
+			facet = &models.SourceFacet{SourceID: h.SourceID, Source: h.Source, SourceType: h.SourceType}
+			facets[h.SourceID] = facet
+		}
+		facet.Count++
+	}
+	for _, facet := range facets {
+		page.Facets = append(page.Facets, *facet)
+	}
+	sort.Slice(page.Facets, func(i, j int) bool {
+		if page.Facets[i].Count != page.Facets[j].Count {
+			return page.Facets[i].Count > page.Facets[j].Count
+		}
+		if page.Facets[i].Source != page.Facets[j].Source {
+			return page.Facets[i].Source < page.Facets[j].Source
+		}
+		return page.Facets[i].SourceID < page.Facets[j].SourceID
+	})
+
+	var likes []likeTerm
+	for _, w := range words {
+		if len([]rune(w)) >= 3 {
+			likes = append(likes, likeTerm{text: w})
+		}
+	}
+	start := min(opts.Offset, len(hits))
+	end := min(start+opts.Limit, len(hits))
+	for _, hit := range hits[start:end] {
+		marked := markMatches(markLikeTerms(hit.highlight.Content, likes))
+		page.Results = append(page.Results, models.SearchResult{
+			Highlight:   hit.highlight,
+			Snippet:     marked,
+			Highlighted: marked,
+			Score:       hit.score,
+		})
+	}
+	if end < len(hits) {
+		page.NextOffset = end
+	}
+	return page, nil
+}
+
+// keywordScores returns bm25 relevance scaled to 0..1 for every highlight
+// containing any of words.
+func (search *Search) keywordScores(words []string) (map[int64]float64, error) {
+	scores := map[int64]float64{}
+	if len(words) == 0 {
+		return scores, nil
+	}
+	parts := make([]string, len(words))
+	for i, w := range words {
+		parts[i] = termNode{text: w}.fts()
+	}
+
+	rows, err := search.Query("SELECT rowid, -bm25(highlights_fts) FROM highlights_fts WHERE content MATCH ?", strings.Join(parts, " OR "))
+	if err != nil {
+		log.Println("Error querying keyword scores:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	best := 0.0
+	for rows.Next() {
+		var rowid int64
+		var score float64
+		if err := rows.Scan(&rowid, &score); err != nil {
+			return nil, err
+		}
+		scores[rowid] = score
+		best = math.Max(best, score)
+	}
+	if best > 0 {
+		for rowid := range scores {
+			scores[rowid] /= best
+		}
+	}
+	return scores, rows.Err()
+}
+
+func encodeVector(vec []float32) []byte {
+	buf := make([]byte, 4*len(vec))
+	for i, x := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vec := make([]float32, len(buf)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vec
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSemanticSearch(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "The Psychology of Money",
+		"Saving money builds wealth over time.",
+		"Investing money builds wealth.",
+		"Wealth grows by investing early.")
+	importTexts(t, db, "Atomic Habits",
+		"Habits shape your identity.",
+		"Your identity shapes your habits over the years.")
+
+	_, err := search.GetSearchResults("investing", SearchOptions{Mode: SearchModeSemantic, Limit: 10})
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("semantic search without an index = %v, want a QueryError", err)
+	}
+
+	if n, err := search.BuildSemanticIndex(2); err != nil || n != 5 {
+		t.Fatalf("BuildSemanticIndex = %d, %v; want 5 highlights", n, err)
+	}
+	favorite := findHighlight(t, db, "The Psychology of Money", "Investing money builds wealth.")
+	if err := db.SetHighlightFlag(favorite.ID, "favorite", true); err != nil {
+		t.Fatal(err)
+	}
+	suspended := findHighlight(t, db, "The Psychology of Money", "Wealth grows by investing early.")
+	if err := db.SetHighlightFlag(suspended.ID, "suspended", true); err != nil {
+		t.Fatal(err)
+	}
+	page, err := search.GetSearchResults("investing", SearchOptions{Mode: SearchModeSemantic, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, result := range page.Results {
+		found[result.Content] = true
+		if result.Source != "The Psychology of Money" {
+			t.Errorf("found %q from %s", result.Content, result.Source)
+		}
+		if result.ID == suspended.ID {
+			t.Errorf("found suspended %q", result.Content)
+		}
+		if result.Favorite != (result.ID == favorite.ID) {
+			t.Errorf("%q favorite = %t", result.Content, result.Favorite)
+		}
+	}
+	// Found for sharing its topic rather than the word
+	if !found["Saving money builds wealth over time."] {
+		t.Errorf("found %d results without the one on saving: %v", page.Total, found)
+	}
+
+	// Highlights added since the index was built are folded in
+	importTexts(t, db, "The Psychology of Money", "Investing is a game of patience.")
+	page, err = search.GetSearchResults("patience", SearchOptions{Mode: SearchModeSemantic, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total == 0 || page.Results[0].Content != "Investing is a game of patience." {
+		t.Errorf("new highlight not found: %+v", page.Results)
+	}
+}
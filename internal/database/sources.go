@@ -0,0 +1,325 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"log"
+	"strings"
+)
+
+func createSourceTables(db *sql.DB) error {
+	// Highlights and source tags refer to sources by id, so two
+	// sources can share a name. Authors are shared between sources;
+	// position keeps their order on the cover.
+	createSourceTablesQuery := `
+	CREATE TABLE IF NOT EXISTS sources (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		source_type TEXT NOT NULL,
+		year INTEGER,
+		isbn TEXT,
+		url TEXT,
+		description TEXT,
+		language TEXT,
+		cover TEXT,
+		feed_url TEXT
+	);
+	CREATE INDEX IF NOT EXISTS sources_name ON sources (name, source_type);
+	CREATE TABLE IF NOT EXISTS authors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE
+	);
+	CREATE TABLE IF NOT EXISTS source_authors (
+		source_id INTEGER NOT NULL,
+		author_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (source_id, author_id)
+	);
+	CREATE INDEX IF NOT EXISTS source_authors_author ON source_authors (author_id);`
+
+	if _, err := db.Exec(createSourceTablesQuery); err != nil {
+		return err
+	}
+	return migrateColumns(db, "sources", addedSourceColumns)
+}
+
+// backfillSourceIDs links highlights stored before sources had ids to a
+// source of their name and type, adding the sources that are missing.
+func backfillSourceIDs(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO sources (name, source_type)
+		SELECT h.source, COALESCE(h.source_type, '') FROM highlights h
+		WHERE h.source_id IS NULL AND h.source IS NOT NULL AND NOT EXISTS (
+			SELECT 1 FROM sources s WHERE s.name = h.source AND s.source_type = COALESCE(h.source_type, '')
+		)
+		GROUP BY 1, 2
+		ORDER BY MIN(h.id)`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Println("[sources.go] Added sources of existing highlights:", n)
+	}
+	_, err = tx.Exec(`
+		UPDATE highlights SET source_id = (
+			SELECT MIN(s.id) FROM sources s
+			WHERE s.name = highlights.source AND s.source_type = COALESCE(highlights.source_type, '')
+		)
+		WHERE source_id IS NULL AND source IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE import_batches SET source_id = (
+			SELECT MIN(s.id) FROM sources s
+			WHERE s.name = import_batches.source AND s.source_type = import_batches.source_type
+		)
+		WHERE source_id IS NULL`)
+	if err != nil {
+		return err
+	}
+	if err := migrateSourceTags(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateSourceTags rekeys source_tags by source id in databases where it
+// is still keyed by source name.
+func migrateSourceTags(tx *sql.Tx) error {
+	var byName int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('source_tags') WHERE name = 'source'").Scan(&byName)
+	if err != nil || byName == 0 {
+		return err
+	}
+	log.Println("[sources.go] Linking source tags to source ids")
+	_, err = tx.Exec(`
+		ALTER TABLE source_tags RENAME TO source_tags_by_name;
+		CREATE TABLE source_tags (
+			source_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (source_id, tag_id)
+		);
+		INSERT OR IGNORE INTO source_tags (source_id, tag_id)
+		SELECT s.id, st.tag_id FROM source_tags_by_name st
+		JOIN sources s ON s.name = st.source;
+		DROP TABLE source_tags_by_name;
+		CREATE INDEX IF NOT EXISTS source_tags_tag ON source_tags (tag_id);`)
+	return err
+}
+
+// addedSourceColumns are the columns of sources that databases created by
+// older versions may lack.
+var addedSourceColumns = []string{"feed_url TEXT"}
+
+// sourceColumns are the columns read by scanSource, from sources aliased s.
+const sourceColumns = `s.id, s.name, s.source_type, s.year, s.isbn, s.url,
+	s.description, s.language, s.cover, s.feed_url,
+	(SELECT COUNT(*) FROM sources o WHERE o.name = s.name AND o.source_type = s.source_type AND o.id <= s.id)`
+
+// scanSource reads a source selected with sourceColumns.
+func scanSource(row rowScanner) (models.Source, error) {
+	var source models.Source
+	var year sql.NullInt64
+	var isbn, url, description, language, cover, feedURL sql.NullString
+	err := row.Scan(&source.ID, &source.Name, &source.Type, &year, &isbn, &url, &description, &language, &cover, &feedURL,
+		&source.NameIndex)
+	if err != nil {
+		return source, err
+	}
+	source.Year = int(year.Int64)
+	source.ISBN = isbn.String
+	source.URL = url.String
+	source.Description = description.String
+	source.Language = language.String
+	source.Cover = cover.String
+	source.FeedURL = feedURL.String
+	return source, nil
+}
+
+// GetSource returns the source with the given id and its metadata. It
+// returns sql.ErrNoRows if there is none or it has no highlights.
+func (db *Db) GetSource(id int) (models.Source, error) {
+	source, err := scanSource(db.QueryRow(`
+		SELECT `+sourceColumns+` FROM sources s
+		WHERE s.id = ? AND EXISTS (SELECT 1 FROM highlights WHERE source_id = s.id)`, id))
+	if err != nil {
+		return source, err
+	}
+	sources := []models.Source{source}
+	if err := db.attachAuthors(sources); err != nil {
+		return source, err
+	}
+	err = db.attachSourceTags(sources)
+	return sources[0], err
+}
+
+// SourceByID returns the source with the given id, whether or not it has
+// highlights, without its authors and tags. It returns sql.ErrNoRows if
+// there is none.
+func (db *Db) SourceByID(id int) (models.Source, error) {
+	return scanSource(db.QueryRow("SELECT "+sourceColumns+" FROM sources s WHERE s.id = ?", id))
+}
+
+// LookupSource returns the id of the index-th source, counting from 1 and
+// oldest first, with the given name and type, or 0 if there are fewer.
+func (db *Db) LookupSource(name, sourceType string, index int) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM sources WHERE name = ? AND source_type = ? ORDER BY id LIMIT 1 OFFSET ?",
+		name, sourceType, index-1).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Println("[sources.go] Error looking up source:", name, err)
+	}
+	return id, err
+}
+
+// CreateSource adds a source with the given name and type, even if one
+// with that name exists, and returns its id.
+func (db *Db) CreateSource(name, sourceType string) (int, error) {
+	res, err := db.Exec("INSERT INTO sources (name, source_type) VALUES (?, ?)", name, sourceType)
+	if err != nil {
+		log.Println("[sources.go] Error creating source:", name, err)
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// SourceFor returns the id of the index-th source with the given name and
+// type, as LookupSource, creating it and any missing before it.
+func (db *Db) SourceFor(name, sourceType string, index int) (int, error) {
+	for {
+		id, err := db.LookupSource(name, sourceType, index)
+		if err != nil || id != 0 {
+			return id, err
+		}
+		if _, err := db.CreateSource(name, sourceType); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// NormalizeISBN strips the hyphens and spaces ISBNs are usually printed
+// with and uppercases the rest, so ISBNs and ASINs compare as typed.
+func NormalizeISBN(isbn string) string {
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	return strings.ToUpper(strings.TrimSpace(isbn))
+}
+
+// SetSourceMetadata saves the metadata of source, found by ID, and its
+// authors by name, replacing what was saved before. source.Name,
+// source.Authors and source.Cover are ignored; use SetSourceCover for the
+// cover. It returns sql.ErrNoRows if there is no such source.
+func (db *Db) SetSourceMetadata(source models.Source, authors []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[sources.go] Error beginning transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var year any
+	if source.Year != 0 {
+		year = source.Year
+	}
+	res, err := tx.Exec(`
+		UPDATE sources SET year = ?, isbn = ?, url = ?, description = ?, language = ?
+		WHERE id = ?`,
+		year, nullString(NormalizeISBN(source.ISBN)), nullString(strings.TrimSpace(source.URL)),
+		nullString(strings.TrimSpace(source.Description)), nullString(strings.TrimSpace(source.Language)), source.ID)
+	if err != nil {
+		log.Println("[sources.go] Error saving source metadata:", source.ID, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec("DELETE FROM source_authors WHERE source_id = ?", source.ID); err != nil {
+		log.Println("[sources.go] Error clearing source authors:", err)
+		return err
+	}
+	for position, author := range NormalizeAuthors(authors) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO authors (name) VALUES (?)", author); err != nil {
+			log.Println("[sources.go] Error creating author:", author, err)
+			return err
+		}
+		var authorID int
+		if err := tx.QueryRow("SELECT id FROM authors WHERE name = ?", author).Scan(&authorID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT OR IGNORE INTO source_authors (source_id, author_id, position) VALUES (?, ?, ?)",
+			source.ID, authorID, position)
+		if err != nil {
+			log.Println("[sources.go] Error adding source author:", author, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetSourceCover records the cover file of a source; an empty name removes
+// the cover.
+func (db *Db) SetSourceCover(id int, cover string) error {
+	_, err := db.Exec("UPDATE sources SET cover = ? WHERE id = ?", nullString(cover), id)
+	if err != nil {
+		log.Println("[sources.go] Error saving source cover:", id, err)
+	}
+	return err
+}
+
+// NormalizeAuthors trims author names and drops empty and repeated ones,
+// keeping their order. Input may be comma separated.
+func NormalizeAuthors(input []string) []string {
+	seen := map[string]bool{}
+	var authors []string
+	for _, item := range input {
+		for _, author := range strings.Split(item, ",") {
+			author = strings.Join(strings.Fields(author), " ")
+			key := strings.ToLower(author)
+			if author == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			authors = append(authors, author)
+		}
+	}
+	return authors
+}
+
+// attachAuthors fills in the Authors of each source.
+func (db *Db) attachAuthors(sources []models.Source) error {
+	rows, err := db.Query(`
+		SELECT sa.source_id, a.id, a.name FROM source_authors sa
+		JOIN authors a ON a.id = sa.author_id
+		ORDER BY sa.source_id, sa.position`)
+	if err != nil {
+		log.Println("[sources.go] Error querying source authors:", err)
+		return err
+	}
+	defer rows.Close()
+
+	byID := map[int][]models.Author{}
+	for rows.Next() {
+		var id int
+		var author models.Author
+		if err := rows.Scan(&id, &author.ID, &author.Name); err != nil {
+			return err
+		}
+		byID[id] = append(byID[id], author)
+	}
+	for i := range sources {
+		sources[i].Authors = byID[sources[i].ID]
+	}
+	return rows.Err()
+}
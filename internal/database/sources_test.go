@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSourcesWithTheSameName(t *testing.T) {
+	db, _ := openTestDB(t)
+	first := importTexts(t, db, "Walden", "Simplify, simplify.")
+	second, err := db.SourceFor("Walden", "book", 2)
+	if err != nil {
+		t.Fatalf("SourceFor: %v", err)
+	}
+	if second == first.SourceID {
+		t.Fatalf("second Walden got the id of the first, %d", second)
+	}
+	// The same text is not a duplicate in another source of that name
+	batch := &models.ImportBatch{Importer: "test", Format: "text", SourceID: second, Source: "Walden", SourceType: "book"}
+	if err := db.ImportHighlights(batch, bookHighlights("Walden", "Simplify, simplify.")); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+	if batch.Inserted != 1 {
+		t.Errorf("second Walden inserted %d highlights, want 1", batch.Inserted)
+	}
+
+	err = db.SetSourceMetadata(models.Source{ID: second, Year: 1854}, []string{"Henry David Thoreau"})
+	if err != nil {
+		t.Fatalf("SetSourceMetadata: %v", err)
+	}
+	sources, err := db.GetSources(HighlightFilter{})
+	if err != nil {
+		t.Fatalf("GetSources: %v", err)
+	}
+	var got [][2]int
+	for _, source := range sources {
+		got = append(got, [2]int{source.NameIndex, source.Year})
+	}
+	if want := [][2]int{{1, 0}, {2, 1854}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sources (name index, year) = %v, want %v", got, want)
+	}
+	source, err := db.GetSource(second)
+	if err != nil || len(source.Authors) != 1 || source.Authors[0].Name != "Henry David Thoreau" {
+		t.Errorf("GetSource = %+v, %v; want the second Walden's author", source, err)
+	}
+
+	if err := db.SetSourceMetadata(models.Source{ID: -1}, nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetSourceMetadata of a missing source = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := db.CreateSource("Emptied", "book"); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := db.GetEmptySources()
+	if err != nil || len(empty) != 1 || empty[0].Name != "Emptied" {
+		t.Errorf("GetEmptySources = %+v, %v; want Emptied", empty, err)
+	}
+}
+
+func TestBackfillSourceIDs(t *testing.T) {
+	// A library from before sources had ids, with source tags keyed by name
+	path := filepath.Join(t.TempDir(), "highlights.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+		CREATE TABLE highlights (id INTEGER PRIMARY KEY AUTOINCREMENT, source TEXT, source_type TEXT, content TEXT);
+		INSERT INTO highlights (source, source_type, content) VALUES
+			('Walden', 'book', 'Simplify, simplify.'),
+			('Huberman Lab', 'podcast', 'Get morning light.'),
+			('Walden', 'book', 'Go to the woods.');
+		CREATE TABLE tags (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE COLLATE NOCASE);
+		CREATE TABLE source_tags (source TEXT NOT NULL, tag_id INTEGER NOT NULL, PRIMARY KEY (source, tag_id));
+		INSERT INTO tags (name) VALUES ('nature');
+		INSERT INTO source_tags (source, tag_id) VALUES ('Walden', 1);`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := InitDb(path)
+	if err != nil {
+		t.Fatalf("InitDb: %v", err)
+	}
+	defer db.Close()
+	sources, err := db.GetSources(HighlightFilter{})
+	if err != nil {
+		t.Fatalf("GetSources: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Name != "Walden" || sources[1].Name != "Huberman Lab" {
+		t.Fatalf("sources = %+v, want Walden and Huberman Lab in order", sources)
+	}
+	if highlights, err := db.GetSourceHighlights(sources[0].ID); err != nil || len(highlights) != 2 {
+		t.Errorf("Walden has %d highlights (%v), want 2", len(highlights), err)
+	}
+	if !reflect.DeepEqual(sources[0].Tags, []string{"nature"}) {
+		t.Errorf("Walden tags = %q, want its tag from before", sources[0].Tags)
+	}
+}
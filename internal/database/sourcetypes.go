@@ -0,0 +1,165 @@
+package database
+
+import (
+	"database/sql"
+	"highlights-anki/internal/models"
+	"log"
+	"slices"
+	"strings"
+)
+
+// defaultSourceTypes are the types every library starts with.
+var defaultSourceTypes = []models.SourceType{
+	{Name: "book", Icon: "📚", Color: "purple", Fields: []string{"authors", "year", "isbn", "language", "url", "description", "cover"}},
+	{Name: "podcast", Icon: "🎙️", Color: "orange", Fields: []string{"authors", "language", "url", "description", "cover", "feed"}},
+}
+
+func createSourceTypesTable(db *sql.DB) error {
+	// Highlights and backup folders name their type, so a type's name never
+	// changes once created. fields is a comma separated list of
+	// models.SourceFields; position orders the types where they are offered.
+	createSourceTypesTableQuery := `
+	CREATE TABLE IF NOT EXISTS source_types (
+		name TEXT PRIMARY KEY,
+		icon TEXT NOT NULL,
+		color TEXT NOT NULL,
+		fields TEXT NOT NULL DEFAULT '',
+		position INTEGER NOT NULL DEFAULT 0
+	);`
+
+	if _, err := db.Exec(createSourceTypesTableQuery); err != nil {
+		return err
+	}
+	for position, t := range defaultSourceTypes {
+		_, err := db.Exec("INSERT OR IGNORE INTO source_types (name, icon, color, fields, position) VALUES (?, ?, ?, ?, ?)",
+			t.Name, t.Icon, t.Color, strings.Join(t.Fields, ","), position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSourceTypes reads the stored source types into db, in the order they
+// are offered.
+func (db *Db) loadSourceTypes() error {
+	rows, err := db.Query("SELECT name, icon, color, fields FROM source_types ORDER BY position, name")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var types []models.SourceType
+	for rows.Next() {
+		var t models.SourceType
+		var fields string
+		if err := rows.Scan(&t.Name, &t.Icon, &t.Color, &fields); err != nil {
+			return err
+		}
+		if fields != "" {
+			t.Fields = strings.Split(fields, ",")
+		}
+		types = append(types, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	db.sourceTypesMu.Lock()
+	defer db.sourceTypesMu.Unlock()
+	db.sourceTypes = types
+	return nil
+}
+
+// SourceTypes returns the source types, in the order they are offered.
+func (db *Db) SourceTypes() []models.SourceType {
+	db.sourceTypesMu.RLock()
+	defer db.sourceTypesMu.RUnlock()
+	return slices.Clone(db.sourceTypes)
+}
+
+// RegisteredSourceType returns the source type with the given name, and
+// whether there is one.
+func (db *Db) RegisteredSourceType(name string) (models.SourceType, bool) {
+	db.sourceTypesMu.RLock()
+	defer db.sourceTypesMu.RUnlock()
+	for _, t := range db.sourceTypes {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return models.SourceType{}, false
+}
+
+// LookupSourceType returns the source type with the given name, or
+// models.DefaultSourceType for a name that is not registered.
+func (db *Db) LookupSourceType(name string) models.SourceType {
+	if t, ok := db.RegisteredSourceType(name); ok {
+		return t
+	}
+	return models.DefaultSourceType(name)
+}
+
+// GetSourceTypes returns the registered source types with how many sources
+// each has.
+func (db *Db) GetSourceTypes() ([]models.SourceType, error) {
+	rows, err := db.Query(`
+		SELECT source_type, COUNT(*) FROM sources
+		WHERE id IN (SELECT source_id FROM highlights)
+		GROUP BY source_type`)
+	if err != nil {
+		log.Println("[sourcetypes.go] Error counting sources by type:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	types := db.SourceTypes()
+	for i := range types {
+		types[i].Sources = counts[types[i].Name]
+	}
+	return types, nil
+}
+
+// SaveSourceType adds a source type, or updates the icon, color and fields
+// of the one with the same name. New types are offered last.
+func (db *Db) SaveSourceType(t models.SourceType) error {
+	_, err := db.Exec(`
+		INSERT INTO source_types (name, icon, color, fields, position)
+		VALUES (?, ?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM source_types))
+		ON CONFLICT (name) DO UPDATE SET
+			icon = excluded.icon,
+			color = excluded.color,
+			fields = excluded.fields`,
+		t.Name, t.Icon, t.Color, strings.Join(t.Fields, ","))
+	if err != nil {
+		log.Println("[sourcetypes.go] Error saving source type:", t.Name, err)
+		return err
+	}
+	return db.loadSourceTypes()
+}
+
+// DeleteSourceType removes a source type no highlight has. It reports
+// whether the type was removed.
+func (db *Db) DeleteSourceType(name string) (bool, error) {
+	res, err := db.Exec(`
+		DELETE FROM source_types WHERE name = ?
+		AND NOT EXISTS (SELECT 1 FROM highlights WHERE source_type = ?)`, name, name)
+	if err != nil {
+		log.Println("[sourcetypes.go] Error deleting source type:", name, err)
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, db.loadSourceTypes()
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"highlights-anki/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestSourceTypes(t *testing.T) {
+	db, _ := openTestDB(t)
+	importTexts(t, db, "Walden", "Simplify, simplify.")
+
+	var names []string
+	for _, sourceType := range db.SourceTypes() {
+		names = append(names, sourceType.Name)
+	}
+	if want := []string{"book", "podcast"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("default source types = %q, want %q", names, want)
+	}
+
+	article := models.SourceType{Name: "article", Icon: "📰", Color: "green", Fields: []string{"authors", "url"}}
+	if err := db.SaveSourceType(article); err != nil {
+		t.Fatalf("SaveSourceType: %v", err)
+	}
+	if got, ok := db.RegisteredSourceType("article"); !ok || !got.Has("url") || got.Has("isbn") {
+		t.Errorf("RegisteredSourceType(article) = %+v, %v; want its saved fields", got, ok)
+	}
+	if got := db.LookupSourceType("zine"); got.Name != "zine" || !got.Has("cover") {
+		t.Errorf("LookupSourceType(zine) = %+v, want the default with every field", got)
+	}
+
+	types, err := db.GetSourceTypes()
+	if err != nil {
+		t.Fatalf("GetSourceTypes: %v", err)
+	}
+	counts := map[string]int{}
+	for _, sourceType := range types {
+		counts[sourceType.Name] = sourceType.Sources
+	}
+	if want := map[string]int{"book": 1, "podcast": 0, "article": 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("source counts = %v, want %v", counts, want)
+	}
+
+	// A type is kept while highlights have it
+	if removed, err := db.DeleteSourceType("book"); err != nil || removed {
+		t.Errorf("DeleteSourceType(book) = %v, %v; want it kept", removed, err)
+	}
+	if removed, err := db.DeleteSourceType("article"); err != nil || !removed {
+		t.Errorf("DeleteSourceType(article) = %v, %v; want it removed", removed, err)
+	}
+	if _, ok := db.RegisteredSourceType("article"); ok {
+		t.Error("article is still registered after it was removed")
+	}
+}
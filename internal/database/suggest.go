@@ -0,0 +1,234 @@
+package database
+
+import (
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The vocabulary of highlights_fts holds porter stems ("improv" for
+// "improvement"), so suggestions are mapped back to a word that actually
+// appears in a highlight before they are shown.
+
+// GetCompletions returns up to limit completions for the last word of
+// query, most frequent first. Each completion is the whole query with its
+// last word replaced.
+func (search *Search) GetCompletions(query string, limit int) ([]string, error) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 || unicode.IsSpace(lastRune(query)) {
+		return nil, nil
+	}
+	prefix := strings.ToLower(fields[len(fields)-1])
+	if !isPlainWord(prefix) {
+		return nil, nil
+	}
+
+	rows, err := search.Query(`
+		SELECT term FROM highlights_vocab
+		WHERE term >= ? AND term < ?
+		ORDER BY doc DESC, term
+		LIMIT ?`, prefix, prefix+string(utf8.MaxRune), limit*2)
+	if err != nil {
+		log.Println("Error querying FTS vocabulary:", err)
+		return nil, err
+	}
+
+	var terms []string
+	for rows.Next() {
+		var term string
+		if err := rows.Scan(&term); err != nil {
+			rows.Close()
+			log.Println("Error scanning FTS vocabulary row:", err)
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	head := strings.Join(fields[:len(fields)-1], " ")
+	seen := map[string]bool{}
+	var completions []string
+	for _, term := range terms {
+		word, err := search.surfaceForm(term)
+		if err != nil {
+			return nil, err
+		}
+		if seen[word] || !strings.HasPrefix(word, prefix) {
+			continue
+		}
+		seen[word] = true
+		completions = append(completions, strings.TrimSpace(head+" "+word))
+		if len(completions) == limit {
+			break
+		}
+	}
+	return completions, nil
+}
+
+// GetSpellingSuggestion returns query with each word that matches nothing
+// replaced by the closest word in the vocabulary, or "" when there is
+// nothing to correct.
+func (search *Search) GetSpellingSuggestion(query string) (string, error) {
+	fields := strings.Fields(query)
+	changed := false
+	for i, field := range fields {
+		word := strings.ToLower(field)
+		if !isPlainWord(word) {
+			continue
+		}
+
+		var found int
+		err := search.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM highlights_fts WHERE content MATCH ? LIMIT 1)", `"`+word+`"`).Scan(&found)
+		if err != nil {
+			log.Println("Error checking word against FTS table:", err)
+			return "", err
+		}
+		if found > 0 {
+			continue
+		}
+
+		term, err := search.closestTerm(word)
+		if err != nil {
+			return "", err
+		}
+		if term == "" {
+			continue
+		}
+		replacement, err := search.surfaceForm(term)
+		if err != nil {
+			return "", err
+		}
+		if replacement != word {
+			fields[i] = replacement
+			changed = true
+		}
+	}
+
+	if !changed {
+		return "", nil
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// closestTerm finds the vocabulary term nearest to word by edit distance,
+// preferring terms that occur in more highlights.
+func (search *Search) closestTerm(word string) (string, error) {
+	n := utf8.RuneCountInString(word)
+	maxDist := 1
+	switch {
+	case n > 8:
+		maxDist = 3
+	case n > 4:
+		maxDist = 2
+	}
+
+	rows, err := search.Query(`
+		SELECT term, doc FROM highlights_vocab
+		WHERE length(term) BETWEEN ? AND ?`, n-maxDist-3, n+maxDist)
+	if err != nil {
+		log.Println("Error querying FTS vocabulary:", err)
+		return "", err
+	}
+	defer rows.Close()
+
+	best, bestDist, bestDocs := "", maxDist+1, 0
+	for rows.Next() {
+		var term string
+		var docs int
+		if err := rows.Scan(&term, &docs); err != nil {
+			log.Println("Error scanning FTS vocabulary row:", err)
+			return "", err
+		}
+
+		// Stems are usually a prefix of the word, so also compare against
+		// the start of the word, at the cost of one extra edit.
+		dist := editDistance(word, term)
+		if m := utf8.RuneCountInString(term) + 1; m < n {
+			if d := editDistance(string([]rune(word)[:m]), term) + 1; d < dist {
+				dist = d
+			}
+		}
+
+		if dist > maxDist {
+			continue
+		}
+		if dist < bestDist || (dist == bestDist && docs > bestDocs) {
+			best, bestDist, bestDocs = term, dist, docs
+		}
+	}
+	return best, rows.Err()
+}
+
+// surfaceForm maps a vocabulary stem to the shortest word starting with it
+// in a highlight that contains it, e.g. "improv" to "improvement".
+func (search *Search) surfaceForm(term string) (string, error) {
+	rows, err := search.Query(`SELECT content FROM highlights_fts WHERE content MATCH ? LIMIT 5`, `"`+term+`"`)
+	if err != nil {
+		log.Println("Error querying FTS table for surface form:", err)
+		return "", err
+	}
+	defer rows.Close()
+
+	best := ""
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return "", err
+		}
+		words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, w := range words {
+			if strings.HasPrefix(w, term) && (best == "" || len(w) < len(best)) {
+				best = w
+			}
+		}
+	}
+	if best == "" {
+		best = term
+	}
+	return best, rows.Err()
+}
+
+func isPlainWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// editDistance is the Levenshtein distance between a and b in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggestions(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"Habits are the compound interest of self-improvement.",
+		"Improvement comes from small habits.",
+		"Identity drives habits.")
+
+	completions, err := search.GetCompletions("small hab", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"small habits"}; !reflect.DeepEqual(completions, want) {
+		t.Errorf("GetCompletions = %q, want %q", completions, want)
+	}
+	// Completions show words as written, not their stems
+	completions, err = search.GetCompletions("impro", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"improvement"}; !reflect.DeepEqual(completions, want) {
+		t.Errorf("GetCompletions = %q, want %q", completions, want)
+	}
+	if completions, _ := search.GetCompletions("habits ", 5); completions != nil {
+		t.Errorf("GetCompletions after a space = %q, want none", completions)
+	}
+
+	tests := []struct {
+		query, want string
+	}{
+		{"habbits", "habits"},
+		{"compund intrest", "compound interest"},
+		{"habits", ""},
+		{"zzzzzzzzzz", ""},
+	}
+	for _, tt := range tests {
+		got, err := search.GetSpellingSuggestion(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GetSpellingSuggestion(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	page, err := search.GetSearchResults("habbits", SearchOptions{Mode: SearchModeWords, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 0 || page.Suggestion != "habits" {
+		t.Errorf("search for a misspelling found %d and suggested %q, want 0 and habits", page.Total, page.Suggestion)
+	}
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"database/sql"
+	"highlights-anki/internal/models"
+	"log"
+	"sort"
+	"strings"
+)
+
+func createTagTables(db *sql.DB) error {
+	// Tags on a source apply to all of its highlights when filtering.
+	createTagTablesQuery := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE
+	);
+	CREATE TABLE IF NOT EXISTS highlight_tags (
+		highlight_id INTEGER NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY (highlight_id, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS highlight_tags_tag ON highlight_tags (tag_id);
+	CREATE TABLE IF NOT EXISTS source_tags (
+		source_id INTEGER NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY (source_id, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS source_tags_tag ON source_tags (tag_id);
+
+	CREATE TRIGGER IF NOT EXISTS highlights_untag AFTER DELETE ON highlights BEGIN
+		DELETE FROM highlight_tags WHERE highlight_id = old.id;
+	END;`
+
+	_, err := db.Exec(createTagTablesQuery)
+	return err
+}
+
+// taggedHighlightIDs selects the ids of highlights carrying a tag, either
+// directly or through their source. It takes the tag name twice.
+const taggedHighlightIDs = `
+	SELECT ht.highlight_id FROM highlight_tags ht
+	JOIN tags t ON t.id = ht.tag_id
+	WHERE t.name = ?
+	UNION
+	SELECT h.id FROM highlights h
+	JOIN source_tags st ON st.source_id = h.source_id
+	JOIN tags t ON t.id = st.tag_id
+	WHERE t.name = ?`
+
+// NormalizeTags lowercases tags, collapses their whitespace and drops
+// empty and repeated ones. Input may be comma separated.
+func NormalizeTags(input []string) []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, item := range input {
+		for _, tag := range strings.Split(item, ",") {
+			tag = strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+			tag = strings.TrimPrefix(tag, "#")
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// SetHighlightTags replaces the tags of a highlight. It returns
+// sql.ErrNoRows if there is no highlight with that id.
+func (db *Db) SetHighlightTags(id int, tags []string) error {
+	return db.setTags("SELECT 1 FROM highlights WHERE id = ?",
+		"DELETE FROM highlight_tags WHERE highlight_id = ?",
+		"INSERT OR IGNORE INTO highlight_tags (highlight_id, tag_id) VALUES (?, ?)", id, tags)
+}
+
+// SetSourceTags replaces the tags of a source. It returns sql.ErrNoRows if
+// the source has no highlights.
+func (db *Db) SetSourceTags(id int, tags []string) error {
+	return db.setTags("SELECT 1 FROM highlights WHERE source_id = ? LIMIT 1",
+		"DELETE FROM source_tags WHERE source_id = ?",
+		"INSERT OR IGNORE INTO source_tags (source_id, tag_id) VALUES (?, ?)", id, tags)
+}
+
+// setTags replaces the tags of owner in one transaction, after checking
+// with existsQuery that owner exists.
+func (db *Db) setTags(existsQuery, clearQuery, addQuery string, owner any, tags []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("[tags.go] Error beginning transaction:", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow(existsQuery, owner).Scan(&exists); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(clearQuery, owner); err != nil {
+		log.Println("[tags.go] Error clearing tags:", err)
+		return err
+	}
+	for _, tag := range NormalizeTags(tags) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			log.Println("[tags.go] Error creating tag:", tag, err)
+			return err
+		}
+		var tagID int
+		if err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&tagID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(addQuery, owner, tagID); err != nil {
+			log.Println("[tags.go] Error adding tag:", tag, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetTagCounts returns every tag in use with how many highlights and
+// sources carry it directly, most used first.
+func (db *Db) GetTagCounts() ([]models.TagCount, error) {
+	rows, err := db.Query(`
+		SELECT t.name,
+			(SELECT COUNT(*) FROM highlight_tags ht WHERE ht.tag_id = t.id) AS highlights,
+			(SELECT COUNT(*) FROM source_tags st WHERE st.tag_id = t.id) AS sources
+		FROM tags t
+		WHERE highlights + sources > 0
+		ORDER BY highlights + sources DESC, t.name`)
+	if err != nil {
+		log.Println("[tags.go] Error querying tag counts:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.TagCount
+	for rows.Next() {
+		var count models.TagCount
+		if err := rows.Scan(&count.Name, &count.Highlights, &count.Sources); err != nil {
+			log.Println("[tags.go] Error scanning tag count:", err)
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// attachTags fills in the Tags of each highlight.
+func (db *Db) attachTags(highlights []models.Highlight) error {
+	if len(highlights) == 0 {
+		return nil
+	}
+	byID := map[int]*models.Highlight{}
+	args := make([]any, len(highlights))
+	for i := range highlights {
+		byID[highlights[i].ID] = &highlights[i]
+		args[i] = highlights[i].ID
+	}
+
+	rows, err := db.Query(`
+		SELECT ht.highlight_id, t.name FROM highlight_tags ht
+		JOIN tags t ON t.id = ht.tag_id
+		WHERE ht.highlight_id IN (`+placeholders(len(args))+`)
+		ORDER BY t.name`, args...)
+	if err != nil {
+		log.Println("[tags.go] Error querying highlight tags:", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, name)
+	}
+	return rows.Err()
+}
+
+// attachSourceTags fills in the Tags of each source.
+func (db *Db) attachSourceTags(sources []models.Source) error {
+	rows, err := db.Query(`
+		SELECT st.source_id, t.name FROM source_tags st
+		JOIN tags t ON t.id = st.tag_id
+		ORDER BY t.name`)
+	if err != nil {
+		log.Println("[tags.go] Error querying source tags:", err)
+		return err
+	}
+	defer rows.Close()
+
+	byID := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id] = append(byID[id], name)
+	}
+	for i := range sources {
+		sources[i].Tags = byID[sources[i].ID]
+	}
+	return rows.Err()
+}
+
+// GetSourceTags returns the tags of a source.
+func (db *Db) GetSourceTags(id int) ([]string, error) {
+	sources := []models.Source{{ID: id}}
+	if err := db.attachSourceTags(sources); err != nil {
+		return nil, err
+	}
+	return sources[0].Tags, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := NormalizeTags([]string{" Habits,#focus ", "deep   WORK", "habits", ","})
+	want := []string{"deep work", "focus", "habits"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NormalizeTags = %q, want %q", got, want)
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits",
+		"Habits are the compound interest of self-improvement.",
+		"You do not rise to the level of your goals.")
+	importTexts(t, db, "Deep Work", "Deep work habits take practice.")
+	importTexts(t, db, "Walden", "Simplify, simplify.")
+
+	goals := findHighlight(t, db, "Atomic Habits", "You do not rise to the level of your goals.")
+	if err := db.SetHighlightTags(goals.ID, []string{"Focus"}); err != nil {
+		t.Fatalf("SetHighlightTags: %v", err)
+	}
+	if err := db.SetSourceTags(sourceID(t, db, "Deep Work"), []string{"focus", "work"}); err != nil {
+		t.Fatalf("SetSourceTags: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Tagged directly or through the source
+		{"tag:focus", []string{"Deep work habits take practice.", "You do not rise to the level of your goals."}},
+		{"habits tag:focus", []string{"Deep work habits take practice."}},
+		{"tag:work", []string{"Deep work habits take practice."}},
+		{"habits -tag:focus", []string{"Habits are the compound interest of self-improvement."}},
+		{"tag:missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, err := search.GetSearchResults(tt.query, SearchOptions{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, result := range page.Results {
+				got = append(got, contentOf(t, db, result.ID))
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := db.SetHighlightTags(-1, []string{"focus"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetHighlightTags of a missing highlight = %v, want sql.ErrNoRows", err)
+	}
+	if err := db.SetSourceTags(-1, []string{"focus"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetSourceTags of a missing source = %v, want sql.ErrNoRows", err)
+	}
+}
+
+// contentOf returns the stored content of the highlight with the given id.
+func contentOf(t *testing.T, db *Db, id int) string {
+	t.Helper()
+	var content string
+	if err := db.QueryRow("SELECT content FROM highlights WHERE id = ?", id).Scan(&content); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestMergeHighlightsMovesTags(t *testing.T) {
+	db, search := openTestDB(t)
+	importTexts(t, db, "Atomic Habits", "Systems, not goals.", "Systems not goals")
+	keep := findHighlight(t, db, "Atomic Habits", "Systems, not goals.")
+	other := findHighlight(t, db, "Atomic Habits", "Systems not goals")
+	if err := db.SetHighlightTags(other.ID, []string{"systems"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MergeHighlights(keep.ID, []int{keep.ID, other.ID}); err != nil {
+		t.Fatalf("MergeHighlights: %v", err)
+	}
+	page, err := search.GetSearchResults("tag:systems", SearchOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != keep.ID {
+		t.Errorf("tag:systems found %+v, want only the kept highlight", page.Results)
+	}
+}
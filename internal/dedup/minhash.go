@@ -0,0 +1,137 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"math/rand"
+	"strings"
+	"unicode"
+)
+
+// Highlights are short, so they are compared as sets of character
+// shingles rather than word shingles: a changed word or comma then only
+// touches a few of them.
+const (
+	shingleSize = 5
+	numHashes   = 128
+	// bands * rows must equal numHashes. Pairs agreeing on every row of
+	// any band become candidates, which catches pairs with a Jaccard
+	// similarity above roughly (1/bands)^(1/rows) ≈ 0.42.
+	bands = 32
+	rows  = numHashes / bands
+)
+
+// mersennePrime is 2^61-1, the modulus of the universal hash functions.
+const mersennePrime = (1 << 61) - 1
+
+var coefficients = func() [numHashes][2]uint64 {
+	rng := rand.New(rand.NewSource(1))
+	var c [numHashes][2]uint64
+	for i := range c {
+		c[i] = [2]uint64{uint64(rng.Int63n(mersennePrime-1)) + 1, uint64(rng.Int63n(mersennePrime))}
+	}
+	return c
+}()
+
+// Signature is the MinHash signature of a text. The share of positions at
+// which two signatures agree estimates the Jaccard similarity of the texts'
+// shingle sets.
+type Signature [numHashes]uint32
+
+// Normalize lowercases text, turns punctuation into spaces and collapses
+// whitespace, so that texts differing only in those respects compare equal.
+func Normalize(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+			// "don't" and "dont" are the same word
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// Sign returns the signature of text after normalizing it.
+func Sign(text string) Signature {
+	var sig Signature
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+
+	runes := []rune(Normalize(text))
+	n := max(len(runes)-shingleSize+1, 1)
+	for i := 0; i < n; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(runes[i:min(i+shingleSize, len(runes))])))
+		x := h.Sum64() % mersennePrime
+		for j, c := range coefficients {
+			v := uint32(mulmod(c[0], x, c[1]))
+			if v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig
+}
+
+// Similarity estimates the Jaccard similarity of the texts behind a and b.
+func Similarity(a, b *Signature) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / numHashes
+}
+
+// Candidates returns the pairs of indexes into sigs that share at least one
+// locality-sensitive hashing band, each pair once with the lower index
+// first. Only these need comparing, instead of every pair.
+func Candidates(sigs []Signature) [][2]int {
+	seen := map[[2]int]bool{}
+	var pairs [][2]int
+	for band := 0; band < bands; band++ {
+		buckets := map[[rows]uint32][]int{}
+		for i := range sigs {
+			var key [rows]uint32
+			copy(key[:], sigs[i][band*rows:(band+1)*rows])
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for x := 0; x < len(bucket); x++ {
+				for y := x + 1; y < len(bucket); y++ {
+					pair := [2]int{bucket[x], bucket[y]}
+					if !seen[pair] {
+						seen[pair] = true
+						pairs = append(pairs, pair)
+					}
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+// mulmod returns (a*x + b) mod 2^61-1 without overflowing.
+func mulmod(a, x, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, x)
+	// 2^64 ≡ 2^3 (mod 2^61-1)
+	r := (lo & mersennePrime) + (lo >> 61) + (hi << 3)
+	r = (r & mersennePrime) + (r >> 61)
+	r += b
+	r = (r & mersennePrime) + (r >> 61)
+	if r >= mersennePrime {
+		r -= mersennePrime
+	}
+	return r
+}
@@ -0,0 +1,67 @@
+package dedup
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Habits are the compound interest.", "habits are the compound interest"},
+		{"  Don't   STOP—now!  ", "dont stop now"},
+		{"It’s fine, really...", "its fine really"},
+		{"Café 2024", "café 2024"},
+		{"?!", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.text); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+const passage = "You do not rise to the level of your goals. You fall to the level of your systems."
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		other    string
+		min, max float64
+	}{
+		{"identical", passage, 1, 1},
+		{"case and punctuation", "you do not rise to the level of your goals you fall to the level of your systems", 1, 1},
+		{"one word changed", "You do not rise to the level of your goals. You fall to the level of your habits.", 0.6, 1},
+		{"unrelated", "Habits are the compound interest of self-improvement.", 0, 0.2},
+	}
+	a := Sign(passage)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Sign(tt.other)
+			if got := Similarity(&a, &b); got < tt.min || got > tt.max {
+				t.Errorf("Similarity = %.2f, want between %.2f and %.2f", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	sigs := []Signature{
+		Sign(passage),
+		Sign("Habits are the compound interest of self-improvement."),
+		Sign("You do not rise to the level of your goals; you fall to the level of your systems!"),
+		Sign("Every action you take is a vote for the type of person you wish to become."),
+	}
+	pairs := map[[2]int]bool{}
+	for _, pair := range Candidates(sigs) {
+		if pair[0] >= pair[1] {
+			t.Errorf("pair %v is not in index order", pair)
+		}
+		pairs[pair] = true
+	}
+	if !pairs[[2]int{0, 2}] {
+		t.Errorf("Candidates = %v, want the near duplicates 0 and 2", pairs)
+	}
+	if len(pairs) != 1 {
+		t.Errorf("Candidates = %v, want only 0 and 2", pairs)
+	}
+}
@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/models"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DoctorCheck is the outcome of one library integrity check. Fixed is set
+// when Doctor was asked to repair the problems and did.
+type DoctorCheck struct {
+	Name     string
+	Problems []string
+	Fixed    bool
+}
+
+// backupFile is a backup of one source under backups/<type>/, the index-th
+// of those named source, as in BackupPath.
+type backupFile struct {
+	path   string
+	folder string
+	name   string
+	source string
+	index  int
+}
+
+// Doctor checks the database, the search indexes and the backup files for
+// drift between them, repairing what it can when fix is set. The database
+// is treated as the source of truth, except for sources that only exist in
+// backups, which are imported.
+func (op *Operations) Doctor(fix bool) ([]DoctorCheck, error) {
+	var checks []DoctorCheck
+
+	integrity := DoctorCheck{Name: "SQLite integrity"}
+	problems, err := op.DB.IntegrityCheck()
+	if err != nil {
+		return nil, err
+	}
+	// Corruption cannot be repaired from here; restore a copy or reimport
+	// the backups instead
+	integrity.Problems = problems
+	checks = append(checks, integrity)
+
+	duplicates, err := op.checkDuplicates(fix)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, duplicates)
+
+	backups, err := listBackupFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	empty, err := op.checkEmptyBackups(backups, fix)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, empty)
+
+	// After importing backups, which may fill sources that had none
+	sources, err := op.checkEmptySources(fix)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, sources)
+
+	drift, err := op.checkBackups(backups, fix)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, drift)
+
+	// Last, so that the repairs above are reflected in the indexes
+	index := DoctorCheck{Name: "Search indexes"}
+	index.Problems, err = op.Search.CheckIndexes()
+	if err != nil {
+		return nil, err
+	}
+	if fix && len(index.Problems) > 0 {
+		if _, err := op.Search.Reindex(); err != nil {
+			return nil, err
+		}
+		index.Fixed = true
+	}
+	checks = append(checks, index)
+
+	return checks, nil
+}
+
+// checkDuplicates finds highlights with the same source and content hash and
+// keeps only the oldest copy of each when fixing.
+func (op *Operations) checkDuplicates(fix bool) (DoctorCheck, error) {
+	check := DoctorCheck{Name: "Duplicate highlights"}
+	groups, err := op.DB.GetDuplicateHighlights()
+	if err != nil {
+		return check, err
+	}
+
+	var extra []int
+	for _, group := range groups {
+		check.Problems = append(check.Problems, fmt.Sprintf("%s: %d copies of %q", group[0].Source, len(group), preview(group[0].Content)))
+		for _, highlight := range group[1:] {
+			extra = append(extra, highlight.ID)
+		}
+	}
+
+	if fix && len(extra) > 0 {
+		count, err := op.DB.DeleteHighlights(extra)
+		if err != nil {
+			return check, err
+		}
+		log.Printf("Deleted %d duplicate highlights.\n", count)
+		check.Fixed = true
+	}
+	return check, nil
+}
+
+// checkEmptyBackups finds backup files of sources that have no highlights
+// in the database and imports them when fixing.
+func (op *Operations) checkEmptyBackups(backups []backupFile, fix bool) (DoctorCheck, error) {
+	check := DoctorCheck{Name: "Backups without highlights"}
+	for _, backup := range backups {
+		sourceID, err := op.DB.LookupSource(backup.source, backup.folder, backup.index)
+		if err != nil {
+			return check, err
+		}
+		highlights, err := op.DB.GetSourceHighlights(sourceID)
+		if err != nil {
+			return check, err
+		}
+		if len(highlights) > 0 {
+			continue
+		}
+		check.Problems = append(check.Problems, fmt.Sprintf("%s (%s): backup %s has no highlights in the database", backup.source, backup.folder, backup.path))
+		if fix {
+			batch, err := op.indexFile(backup.folder, backup.name, "operations doctor", Splitting{Mode: SplitLines})
+			if err != nil {
+				return check, err
+			}
+			log.Printf("Imported %d highlights from %s as batch %s.\n", batch.Inserted, backup.path, batch.ID)
+		}
+	}
+	check.Fixed = fix && len(check.Problems) > 0
+	return check, nil
+}
+
+// checkEmptySources finds sources in the database that no highlight
+// belongs to and deletes them when fixing.
+func (op *Operations) checkEmptySources(fix bool) (DoctorCheck, error) {
+	check := DoctorCheck{Name: "Sources without highlights"}
+	sources, err := op.DB.GetEmptySources()
+	if err != nil {
+		return check, err
+	}
+
+	ids := make([]int, len(sources))
+	for i, source := range sources {
+		check.Problems = append(check.Problems, fmt.Sprintf("%s (%s): source %d has no highlights", source.Name, source.Type, source.ID))
+		ids[i] = source.ID
+	}
+
+	if fix && len(ids) > 0 {
+		count, err := op.DB.DeleteSources(ids)
+		if err != nil {
+			return check, err
+		}
+		log.Printf("Deleted %d sources without highlights.\n", count)
+		check.Fixed = true
+	}
+	return check, nil
+}
+
+// checkBackups compares every source in the database with its backup file
+// and rewrites the backup from the database when fixing.
+func (op *Operations) checkBackups(backups []backupFile, fix bool) (DoctorCheck, error) {
+	check := DoctorCheck{Name: "Backup files"}
+	sources, err := op.DB.GetSources(database.HighlightFilter{})
+	if err != nil {
+		return check, err
+	}
+
+	byPath := map[string]backupFile{}
+	for _, backup := range backups {
+		byPath[backup.path] = backup
+	}
+
+	for _, source := range sources {
+		highlights, err := op.DB.GetSourceHighlights(source.ID)
+		if err != nil {
+			return check, err
+		}
+
+		backup, ok := byPath[filepath.FromSlash(BackupPath(source))]
+		if !ok {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s (%s): no backup file", source.Name, source.Type))
+			backup.path = BackupPath(source)
+		} else {
+			lines, err := readBackupLines(backup.path)
+			if err != nil {
+				return check, err
+			}
+			missing, extra := compareContents(highlights, lines)
+			if missing == 0 && extra == 0 {
+				continue
+			}
+			check.Problems = append(check.Problems, fmt.Sprintf("%s (%s): %d highlights missing from %s, %d lines not in the database", source.Name, source.Type, missing, backup.path, extra))
+		}
+
+		if fix {
+			if err := os.MkdirAll(filepath.Dir(backup.path), 0o755); err != nil {
+				return check, err
+			}
+			if err := WriteHighlightsToFile(highlights, backup.path); err != nil {
+				return check, err
+			}
+		}
+	}
+	check.Fixed = fix && len(check.Problems) > 0
+	return check, nil
+}
+
+// listBackupFiles returns the files in each backups/<type>/ folder.
+func listBackupFiles() ([]backupFile, error) {
+	folders, err := os.ReadDir("backups")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, folder := range folders {
+		if !folder.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join("backups", folder.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			source, index := ParseBackupFileName(file.Name())
+			backups = append(backups, backupFile{
+				path:   filepath.Join("backups", folder.Name(), file.Name()),
+				folder: folder.Name(),
+				name:   file.Name(),
+				source: source,
+				index:  index,
+			})
+		}
+	}
+	return backups, nil
+}
+
+func readBackupLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	highlights, _ := ParseHighlights(lines, Splitting{}, "", "")
+	contents := make([]string, len(highlights))
+	for i, highlight := range highlights {
+		contents[i] = highlight.Content
+	}
+	return contents, scanner.Err()
+}
+
+// compareContents counts the highlights with no matching backup line and
+// the backup lines with no matching highlight, respecting repeats.
+func compareContents(highlights []models.Highlight, lines []string) (missing, extra int) {
+	counts := map[string]int{}
+	for _, line := range lines {
+		counts[line]++
+	}
+	for _, highlight := range highlights {
+		content := strings.TrimSpace(highlight.Original())
+		if counts[content] > 0 {
+			counts[content]--
+		} else {
+			missing++
+		}
+	}
+	for _, n := range counts {
+		extra += n
+	}
+	return missing, extra
+}
+
+func preview(content string) string {
+	runes := []rune(content)
+	if len(runes) > 60 {
+		return string(runes[:60]) + "…"
+	}
+	return content
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestOperations returns Operations on a fresh library in a temporary
+// working directory, where backups/ is looked for.
+func openTestOperations(t *testing.T) *Operations {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	db, err := database.InitDb("highlights.db")
+	if err != nil {
+		t.Fatalf("InitDb: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	search, err := database.InitSearch("highlights.db")
+	if err != nil {
+		t.Fatalf("InitSearch: %v", err)
+	}
+	t.Cleanup(func() { search.Close() })
+	return NewOperations(db, search)
+}
+
+// writeBackup writes lines to the backup file of a source.
+func writeBackup(t *testing.T, sourceType, source string, lines ...string) {
+	t.Helper()
+	path := filepath.Join("backups", sourceType, source+"_highlights.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// importTexts imports texts as highlights of the book named source and
+// writes its backup, as the admin page does.
+func importTexts(t *testing.T, op *Operations, source string, texts ...string) *models.ImportBatch {
+	t.Helper()
+	id, err := op.DB.SourceFor(source, "book", 1)
+	if err != nil {
+		t.Fatalf("SourceFor: %v", err)
+	}
+	batch := &models.ImportBatch{Importer: "test", Format: "text", SourceID: id, Source: source, SourceType: "book"}
+	highlights := make([]models.Highlight, len(texts))
+	for i, text := range texts {
+		highlights[i] = models.Highlight{Source: source, SourceType: "book", Content: text}
+	}
+	if err := op.DB.ImportHighlights(batch, highlights); err != nil {
+		t.Fatalf("ImportHighlights: %v", err)
+	}
+	if err := RewriteBackup(op.DB, id); err != nil {
+		t.Fatalf("RewriteBackup: %v", err)
+	}
+	return batch
+}
+
+// sourceHighlights returns the highlights of the first book named source.
+func sourceHighlights(t *testing.T, op *Operations, source string) []models.Highlight {
+	t.Helper()
+	id, err := op.DB.LookupSource(source, "book", 1)
+	if err != nil {
+		t.Fatalf("LookupSource: %v", err)
+	}
+	highlights, err := op.DB.GetSourceHighlights(id)
+	if err != nil {
+		t.Fatalf("GetSourceHighlights: %v", err)
+	}
+	return highlights
+}
+
+// checkProblems returns the number of problems of each check by name.
+func checkProblems(t *testing.T, op *Operations, fix bool) map[string]int {
+	t.Helper()
+	checks, err := op.Doctor(fix)
+	if err != nil {
+		t.Fatalf("Doctor: %v", err)
+	}
+	problems := map[string]int{}
+	for _, check := range checks {
+		problems[check.Name] = len(check.Problems)
+		if check.Fixed != (fix && len(check.Problems) > 0) {
+			t.Errorf("%s: Fixed is %t with %d problems", check.Name, check.Fixed, len(check.Problems))
+		}
+	}
+	return problems
+}
+
+func TestDoctor(t *testing.T) {
+	op := openTestOperations(t)
+	importTexts(t, op, "Atomic Habits", "Habits compound.", "Systems over goals.")
+	// A copy from before imports skipped known highlights
+	_, err := op.DB.Exec("INSERT INTO highlights (source_id, source, source_type, content, content_hash) SELECT source_id, source, source_type, content, content_hash FROM highlights WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Left behind by a source whose highlights were all deleted
+	if _, err := op.DB.CreateSource("Emptied", "book"); err != nil {
+		t.Fatal(err)
+	}
+	writeBackup(t, "book", "Atomic Habits", "Habits compound.", "Only in the backup.")
+	writeBackup(t, "book", "Walden", "Simplify, simplify.")
+	// Take one highlight out of the word index behind the triggers' back
+	_, err = op.Search.Exec(`INSERT INTO highlights_fts (highlights_fts, rowid, source, source_type, content)
+		SELECT 'delete', id, source, source_type, content FROM highlights WHERE content = 'Systems over goals.'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"SQLite integrity":           0,
+		"Duplicate highlights":       1,
+		"Backups without highlights": 1,
+		"Sources without highlights": 1,
+		"Backup files":               1,
+		// Missing from the index, which then fails its integrity check
+		"Search indexes": 2,
+	}
+	before := checkProblems(t, op, false)
+	for name, n := range want {
+		if before[name] != n {
+			t.Errorf("%s: %d problems, want %d", name, before[name], n)
+		}
+	}
+	// Checking alone changes nothing
+	if again := checkProblems(t, op, false); again["Duplicate highlights"] != 1 {
+		t.Errorf("checking without fixing removed duplicates")
+	}
+
+	checkProblems(t, op, true)
+	for name, n := range checkProblems(t, op, false) {
+		if n != 0 {
+			t.Errorf("%s: %d problems left after fixing", name, n)
+		}
+	}
+
+	if walden := sourceHighlights(t, op, "Walden"); len(walden) != 1 {
+		t.Errorf("Walden has %d highlights after fixing, want the one from its backup", len(walden))
+	}
+	if id, err := op.DB.LookupSource("Emptied", "book", 1); err != nil || id != 0 {
+		t.Errorf("source without highlights left as %d, %v", id, err)
+	}
+	data, err := os.ReadFile(filepath.Join("backups", "book", "Atomic Habits_highlights.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "Habits compound.\nSystems over goals.\n" {
+		t.Errorf("backup rewritten as %q, want the database's highlights", got)
+	}
+}
+
+func TestUndoImportRewritesBackup(t *testing.T) {
+	op := openTestOperations(t)
+	kept := importTexts(t, op, "Atomic Habits", "Habits compound.")
+	undone := importTexts(t, op, "Atomic Habits", "Systems over goals.")
+	walden := importTexts(t, op, "Walden", "Simplify, simplify.", "Go to the woods.")
+
+	for _, batch := range []*models.ImportBatch{undone, walden} {
+		if err := op.UndoImport(batch.ID); err != nil {
+			t.Fatalf("UndoImport %s: %v", batch.Source, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join("backups", "book", "Atomic Habits_highlights.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "Habits compound.\n" {
+		t.Errorf("backup rewritten as %q, want only the highlight of batch %s", got, kept.ID)
+	}
+	if _, err := os.Stat(filepath.Join("backups", "book", "Walden_highlights.txt")); !os.IsNotExist(err) {
+		t.Errorf("backup of a source left empty still exists: %v", err)
+	}
+
+	// Repairing the library must not bring the removed highlights back
+	checkProblems(t, op, true)
+	for source, want := range map[string]int{"Atomic Habits": 1, "Walden": 0} {
+		if highlights := sourceHighlights(t, op, source); len(highlights) != want {
+			t.Errorf("%s has %d highlights after doctor --fix, want %d", source, len(highlights), want)
+		}
+	}
+}
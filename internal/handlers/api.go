@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("[api.go] Error encoding JSON response:", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// APISearchHandler serves GET /api/search?q=...&mode=...&limit=...&offset=...
+func (h *Handlers) APISearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Query parameter 'q' is required")
+		return
+	}
+
+	page, err := h.Search.GetSearchResults(query, parseSearchOptions(r))
+	var queryErr *database.QueryError
+	if errors.As(err, &queryErr) {
+		writeJSONError(w, http.StatusBadRequest, queryErr.Msg)
+		return
+	}
+	if err != nil {
+		log.Println("[api.go] Error fetching search results:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch search results")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// APISuggestHandler serves GET /api/suggest?q=... with completions for the
+// last word of q.
+func (h *Handlers) APISuggestHandler(w http.ResponseWriter, r *http.Request) {
+	completions, err := h.Search.GetCompletions(r.URL.Query().Get("q"), 8)
+	if err != nil {
+		log.Println("[api.go] Error fetching completions:", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch completions")
+		return
+	}
+	if completions == nil {
+		completions = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"completions": completions})
+}
+
+// APIExportHandler serves GET /api/export, every highlight with its note,
+// tags and provenance as a JSON download.
+func (h *Handlers) APIExportHandler(w http.ResponseWriter, r *http.Request) {
+	highlights, err := h.DB.GetHighlights(database.HighlightFilter{})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch highlights")
+		return
+	}
+	if highlights == nil {
+		highlights = []models.Highlight{}
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="highlights.json"`)
+	writeJSON(w, http.StatusOK, highlights)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// AuthorsHandler serves GET /authors, every author with highlights.
+func (h *Handlers) AuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	authors, err := h.DB.GetAuthors()
+	if err != nil {
+		http.Error(w, "Failed to fetch authors", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "authors.html", authors)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuthorHandler serves GET /authors/{id}, an author's sources and all of
+// their highlights.
+func (h *Handlers) AuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid author id", http.StatusBadRequest)
+		return
+	}
+
+	author, err := h.DB.GetAuthor(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch author", http.StatusInternalServerError)
+		return
+	}
+
+	filter := database.HighlightFilter{AuthorID: id}
+	sources, err := h.DB.GetSources(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch sources", http.StatusInternalServerError)
+		return
+	}
+	highlights, err := h.DB.GetHighlights(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlights", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Author     models.Author
+		Sources    []models.Source
+		Highlights []models.Highlight
+	}{author, sources, highlights}
+	err = h.tmpl.ExecuteTemplate(w, "author.html", data)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
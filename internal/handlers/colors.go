@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ColorCount is a highlight color and how many highlights have it.
+type ColorCount struct {
+	Color string
+	Count int
+}
+
+// HighlightColorHandler serves POST /highlights/{id}/color, setting the
+// highlight's color to the "color" form value, and re-renders its card. An
+// empty value removes the color.
+func (h *Handlers) HighlightColorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+	color := r.FormValue("color")
+	if color != "" && !slices.Contains(models.HighlightColors, color) {
+		http.Error(w, "Unknown color", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.SetHighlightColor(id, color)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save color", http.StatusInternalServerError)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "highlight-card", highlight)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ColorFiltersHandler serves GET /colors, links to review the highlights
+// of each color in use.
+func (h *Handlers) ColorFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.DB.GetColorCounts()
+	if err != nil {
+		http.Error(w, "Failed to fetch colors", http.StatusInternalServerError)
+		return
+	}
+
+	var colors []ColorCount
+	for _, color := range models.HighlightColors {
+		if counts[color] > 0 {
+			colors = append(colors, ColorCount{color, counts[color]})
+		}
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "color-filters", colors)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
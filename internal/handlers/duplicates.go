@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"highlights-anki/internal/database"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// DuplicatesHandler serves GET /admin/duplicates?threshold=..., listing
+// groups of near-duplicate highlights to merge or dismiss.
+func (h *Handlers) DuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := database.DefaultNearDuplicateThreshold
+	if t, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64); err == nil && t > 0 && t <= 1 {
+		threshold = t
+	}
+
+	groups, err := h.DB.GetNearDuplicateHighlights(threshold)
+	if err != nil {
+		http.Error(w, "Failed to find duplicate highlights", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "duplicates.html", groups)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// MergeDuplicatesHandler serves POST /admin/duplicates/merge, keeping the
+// highlight in "keep" and deleting the other "id" values.
+func (h *Handlers) MergeDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ids, ok := parseIDs(w, r)
+	if !ok {
+		return
+	}
+	keep, err := strconv.Atoi(r.FormValue("keep"))
+	if err != nil {
+		http.Error(w, "Choose the highlight to keep", http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.DB.MergeHighlights(keep, ids)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "The highlight to keep must be one of the duplicates", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to merge highlights", http.StatusInternalServerError)
+		return
+	}
+
+	response := fmt.Sprintf(`
+		<div class="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded relative" role="alert">
+			Merged %d duplicates into highlight #%d
+		</div>
+	`, count, keep)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(response))
+}
+
+// DismissDuplicatesHandler serves POST /admin/duplicates/dismiss, marking
+// the "id" values as not being duplicates of each other.
+func (h *Handlers) DismissDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ids, ok := parseIDs(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.DismissDuplicates(ids); err != nil {
+		http.Error(w, "Failed to dismiss duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	response := `
+		<div class="bg-gray-100 border border-gray-300 text-gray-600 px-4 py-3 rounded relative" role="alert">
+			Kept all highlights; they will not be suggested as duplicates again
+		</div>
+	`
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(response))
+}
+
+// parseIDs reads the repeated "id" form values, writing a 400 response and
+// returning false when there are fewer than two or one is not a number.
+func parseIDs(w http.ResponseWriter, r *http.Request) ([]int, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return nil, false
+	}
+	var ids []int
+	for _, value := range r.Form["id"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) < 2 {
+		http.Error(w, "At least two highlights are required", http.StatusBadRequest)
+		return nil, false
+	}
+	return ids, true
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"highlights-anki/internal/podcast"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedClient fetches podcast feeds; large feeds on slow hosts take a while.
+var feedClient = &http.Client{Timeout: 30 * time.Second}
+
+// SourceEpisodesHandler serves POST /sources/episodes, reading the
+// episodes of the podcast with the "source_id" form value from an
+// uploaded "feed" file or the "feed_url" RSS feed, and re-renders the
+// source page.
+func (h *Handlers) SourceEpisodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Failed to parse form .. Size limit exceeded", http.StatusBadRequest)
+		return
+	}
+
+	source, ok := h.formSource(w, r)
+	if !ok {
+		return
+	}
+
+	var episodes []models.Episode
+	feedURL := strings.TrimSpace(r.FormValue("feed_url"))
+	if file, _, err := r.FormFile("feed"); err == nil {
+		defer file.Close()
+		episodes, err = podcast.ParseFeed(file, source.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		feedURL = ""
+	} else if strings.HasPrefix(feedURL, "http://") || strings.HasPrefix(feedURL, "https://") {
+		episodes, err = podcast.FetchFeed(r.Context(), feedClient, feedURL, source.ID)
+		if err != nil {
+			log.Println("[episodes.go] Error fetching feed:", feedURL, err)
+			http.Error(w, "Failed to read feed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	} else {
+		http.Error(w, "Upload a feed file or give an http(s) feed URL", http.StatusBadRequest)
+		return
+	}
+
+	added, err := h.DB.ImportEpisodes(source.ID, feedURL, episodes)
+	if err != nil {
+		http.Error(w, "Failed to save episodes", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[episodes.go] Read %d episodes of %s, %d new\n", len(episodes), source.Name, added)
+
+	h.renderSource(w, r, source.ID)
+}
+
+// HighlightEpisodeHandler serves /highlights/{id}/episode. GET returns a
+// form to pick the episode and timestamp of a podcast highlight; POST
+// saves the "episode_id" and "timestamp" form values, an empty episode
+// removing the link.
+func (h *Handlers) HighlightEpisodeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		highlight, err := h.DB.GetHighlight(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+			return
+		}
+		episodes, err := h.DB.GetEpisodes(highlight.SourceID)
+		if err != nil {
+			http.Error(w, "Failed to fetch episodes", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Highlight models.Highlight
+			Episodes  []models.Episode
+		}{highlight, episodes}
+		err = h.tmpl.ExecuteTemplate(w, "highlight-episode-form", data)
+		if err != nil {
+			log.Println("Error executing template:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	episodeID := 0
+	if value := r.FormValue("episode_id"); value != "" {
+		episodeID, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid episode id", http.StatusBadRequest)
+			return
+		}
+	}
+	timestamp := 0
+	if value := strings.TrimSpace(r.FormValue("timestamp")); value != "" {
+		timestamp, err = podcast.ParseTimestamp(value)
+		if err != nil {
+			http.Error(w, "Timestamp must look like 34:12 or 1:02:03", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.DB.SetHighlightEpisode(id, episodeID, timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "No such highlight, or the episode is from another source", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save episode", http.StatusInternalServerError)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+	err = h.tmpl.ExecuteTemplate(w, "highlight-episode", highlight)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
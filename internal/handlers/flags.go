@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// HighlightFlagHandler serves POST /highlights/{id}/flag, turning the flag
+// named by the "flag" form value on or off as the boolean "on" form value
+// says, and re-renders the highlight's card.
+func (h *Handlers) HighlightFlagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+	flag := r.FormValue("flag")
+	if !slices.Contains(models.HighlightFlags, flag) {
+		http.Error(w, "Unknown flag", http.StatusBadRequest)
+		return
+	}
+	on, err := strconv.ParseBool(r.FormValue("on"))
+	if err != nil {
+		http.Error(w, "Invalid value for on", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.SetHighlightFlag(id, flag, on)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save flag", http.StatusInternalServerError)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "highlight-card", highlight)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// FavoritesHandler serves GET /favorites, every favorite highlight with
+// the pinned ones first.
+func (h *Handlers) FavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	highlights, err := h.DB.GetHighlights(database.HighlightFilter{Favorite: true})
+	if err != nil {
+		http.Error(w, "Failed to fetch favorites", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "favorites.html", highlights)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
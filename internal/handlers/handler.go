@@ -1,25 +1,45 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"fmt"
 	"highlights-anki/internal"
+	"highlights-anki/internal/card"
 	"highlights-anki/internal/database"
+	"highlights-anki/internal/markdown"
+	"highlights-anki/internal/metadata"
 	"highlights-anki/internal/models"
+	"highlights-anki/internal/normalize"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
 type Handlers struct {
-	DB     *database.Db
-	tmpl   *template.Template
-	Search *database.Search
+	DB       *database.Db
+	tmpl     *template.Template
+	Search   *database.Search
+	Metadata metadata.Provider
+	// Normalizer cleans up the text of uploaded highlights.
+	Normalizer *normalize.Normalizer
+}
+
+// TemplateFuncs returns the functions available to the templates: markdown
+// renders a note, and sourceType looks up a source type of db by name.
+func TemplateFuncs(db *database.Db) template.FuncMap {
+	return template.FuncMap{
+		"markdown":   markdown.Render,
+		"sourceType": db.LookupSourceType,
+	}
 }
 
 func NewHandlers(db *database.Db, search *database.Search) *Handlers {
-	tmpl, err := template.ParseGlob("templates/*.html")
+	tmpl, err := template.New("").Funcs(TemplateFuncs(db)).ParseGlob("templates/*.html")
 
 	// Debug: List all parsed templates
 	// for _, t := range tmpl.Templates() {
@@ -28,12 +48,26 @@ func NewHandlers(db *database.Db, search *database.Search) *Handlers {
 	if err != nil {
 		panic(err)
 	}
-	return &Handlers{DB: db, tmpl: tmpl, Search: search}
+	return &Handlers{DB: db, tmpl: tmpl, Search: search, Metadata: metadata.NewOpenLibrary("", ""), Normalizer: normalize.Default()}
+}
+
+// highlightFilter reads the "tag", "author", "color", "kind" and
+// "favorite" query parameters that narrow random review and source
+// listings.
+func highlightFilter(r *http.Request) database.HighlightFilter {
+	authorID, _ := strconv.Atoi(r.URL.Query().Get("author"))
+	return database.HighlightFilter{
+		Tag:      r.URL.Query().Get("tag"),
+		AuthorID: authorID,
+		Color:    r.URL.Query().Get("color"),
+		Kind:     r.URL.Query().Get("kind"),
+		Favorite: r.URL.Query().Get("favorite") != "",
+	}
 }
 
 func (h *Handlers) GetRandomHighlights(w http.ResponseWriter, r *http.Request) {
 	log.Println("Fetching random highlights...")
-	randomHighlights, err := h.DB.GetRandomHighlights(10)
+	randomHighlights, err := h.DB.GetRandomHighlights(10, highlightFilter(r))
 	if err != nil {
 		http.Error(w, "Failed to fetch random highlights", http.StatusInternalServerError)
 		return
@@ -42,7 +76,7 @@ func (h *Handlers) GetRandomHighlights(w http.ResponseWriter, r *http.Request) {
 
 	err = h.tmpl.ExecuteTemplate(w, "highlights.html", randomHighlights)
 	if err != nil {
-		log.Fatal("Error executing template:", err)
+		log.Println("Error executing template:", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
 		return
 	}
@@ -50,46 +84,141 @@ func (h *Handlers) GetRandomHighlights(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) SourcesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Fetching sources...")
-	sources, err := h.DB.GetSources()
+	filter := highlightFilter(r)
+	sources, err := h.DB.GetSources(filter)
 
 	if err != nil {
-		log.Fatal("Error fetching sources: %v", err)
+		log.Printf("Error fetching sources: %v", err)
 		http.Error(w, "Failed to fetch sources", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.tmpl.ExecuteTemplate(w, "sources.html", sources)
+	data := struct {
+		Tag     string
+		Sources []models.Source
+	}{filter.Tag, sources}
+	err = h.tmpl.ExecuteTemplate(w, "sources.html", data)
 
 	if err != nil {
-		log.Fatal("Error executing template: %v", err)
+		log.Printf("Error executing template: %v", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
 		return
 	}
 }
 
+// SourceHighlightsHandler serves GET /sources/{id}, the source page.
 func (h *Handlers) SourceHighlightsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("[handler.go] SourceHighlightsHandler called")
-	sourceName := strings.TrimPrefix(r.URL.Path, "/source/")
-	if sourceName == "" {
-		http.Error(w, "Source Name not passed", http.StatusBadRequest)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid source id", http.StatusBadRequest)
+		return
+	}
+
+	h.renderSource(w, r, id)
+}
+
+// SourceNameRedirectHandler serves GET /source/{name}, the source page's
+// address before sources had ids, redirecting to the oldest source of that
+// name so old links and bookmarks keep working.
+func (h *Handlers) SourceNameRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	sources, err := h.DB.GetSources(database.HighlightFilter{})
+	if err != nil {
+		http.Error(w, "Failed to fetch sources", http.StatusInternalServerError)
+		return
+	}
+	for _, source := range sources {
+		if source.Name == name {
+			http.Redirect(w, r, fmt.Sprintf("/sources/%d", source.ID), http.StatusMovedPermanently)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (h *Handlers) HighlightCardHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("[handler.go] HighlightCardHandler called")
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+
+	themeName := r.URL.Query().Get("theme")
+	if themeName == "" {
+		themeName = card.DefaultTheme
+	}
+	theme, ok := card.Themes[themeName]
+	if !ok {
+		http.Error(w, "Unknown theme: "+themeName, http.StatusBadRequest)
 		return
 	}
 
-	highlights, err := h.DB.GetSourceHighlights(sourceName)
+	sizeName := r.URL.Query().Get("size")
+	if sizeName == "" {
+		sizeName = card.DefaultSize
+	}
+	size, ok := card.Sizes[sizeName]
+	if !ok {
+		http.Error(w, "Unknown size: "+sizeName, http.StatusBadRequest)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = card.Render(&buf, highlight, theme, size)
 	if err != nil {
-		log.Fatal("Error fetching source highlights: %v", err)
-		http.Error(w, "Failed to fetch source highlights", http.StatusInternalServerError)
+		log.Println("Error rendering card:", err)
+		http.Error(w, "Failed to render card", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.tmpl.ExecuteTemplate(w, "highlights.html", highlights)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
 
+func (h *Handlers) RelatedHighlightsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("[handler.go] RelatedHighlightsHandler called")
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		log.Fatal("Error executing template: %v", err)
-		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
 		return
 	}
 
+	highlight, err := h.DB.GetHighlight(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	related, err := h.Search.GetRelatedHighlights(highlight, 5)
+	if err != nil {
+		log.Println("Error fetching related highlights:", err)
+		http.Error(w, "Failed to fetch related highlights", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "related.html", related)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
 }
 
 func (h *Handlers) SearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +226,7 @@ func (h *Handlers) SearchHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := h.tmpl.ExecuteTemplate(w, "search.html", nil)
 	if err != nil {
-		log.Fatal("Error executing template:", err)
+		log.Println("Error executing template:", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
 		return
 	}
@@ -111,14 +240,32 @@ func (h *Handlers) SearchResultsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	results, err := h.Search.GetSearchResults(query, 5)
+	opts := parseSearchOptions(r)
+	page, err := h.Search.GetSearchResults(query, opts)
+	var queryErr *database.QueryError
+	if errors.As(err, &queryErr) {
+		response := fmt.Sprintf(`
+		<div class="bg-yellow-50 border border-yellow-200 text-yellow-800 px-4 py-3 rounded" role="alert">
+			%s
+		</div>
+	`, template.HTMLEscapeString(queryErr.Msg))
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(response))
+		return
+	}
 	if err != nil {
 		log.Println("Error fetching search results:", err)
 		http.Error(w, "Failed to fetch search results", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.tmpl.ExecuteTemplate(w, "search-results.html", results)
+	// "Load more" requests only need the next rows, appended in place
+	templateName := "search-results.html"
+	if opts.Offset > 0 {
+		templateName = "search-result-items"
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, templateName, page)
 	if err != nil {
 		log.Println("Error executing template:", err)
 		http.Error(w, "Failed to render template", http.StatusInternalServerError)
@@ -126,6 +273,54 @@ func (h *Handlers) SearchResultsHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// SearchSuggestHandler returns <option> completions for the word being
+// typed, for the search box's datalist.
+func (h *Handlers) SearchSuggestHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	completions, err := h.Search.GetCompletions(query, 8)
+	if err != nil {
+		log.Println("Error fetching completions:", err)
+		http.Error(w, "Failed to fetch completions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	for _, completion := range completions {
+		fmt.Fprintf(w, "<option value=\"%s\"></option>\n", template.HTMLEscapeString(completion))
+	}
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func parseSearchOptions(r *http.Request) database.SearchOptions {
+	limit, offset := parsePagination(r, defaultSearchLimit)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = database.SearchModeAuto
+	}
+	return database.SearchOptions{Mode: mode, Limit: limit, Offset: offset}
+}
+
+// parsePagination reads the limit and offset query parameters, falling back
+// to defaultLimit and clamping the limit to maxSearchLimit.
+func parsePagination(r *http.Request, defaultLimit int) (limit, offset int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
 func (h *Handlers) AddHighlights(w http.ResponseWriter, r *http.Request) {
 	println("AddHighlights called")
 	if r.Method != http.MethodPost {
@@ -143,21 +338,56 @@ func (h *Handlers) AddHighlights(w http.ResponseWriter, r *http.Request) {
 	sourceType := r.FormValue("source_type")
 	highlightsText := r.FormValue("highlights_text")
 
+	// A "source_id" adds to that source whatever its name. Otherwise the
+	// highlights go to the oldest source of that name and type, or to a
+	// new one if there is none or "new_source" asks for one
+	sourceID := 0
+	if r.FormValue("source_id") != "" {
+		source, ok := h.formSource(w, r)
+		if !ok {
+			return
+		}
+		sourceID, sourceName, sourceType = source.ID, source.Name, source.Type
+	}
+
 	if sourceName == "" || sourceType == "" {
 		http.Error(w, "Source name and type are required", http.StatusBadRequest)
 		return
 	}
+	if _, ok := h.DB.RegisteredSourceType(sourceType); !ok {
+		http.Error(w, "Unknown source type", http.StatusBadRequest)
+		return
+	}
+	splitting, err := internal.NewSplitting(r.FormValue("split"), r.FormValue("delimiter"))
+	if err != nil {
+		http.Error(w, "Invalid splitting: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sourceID == 0 && r.FormValue("new_source") == "" {
+		sourceID, err = h.DB.LookupSource(sourceName, sourceType, 1)
+		if err != nil {
+			http.Error(w, "Failed to fetch source", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	var lines []string
+	var entries []string
+	batch := &models.ImportBatch{
+		Importer:   "web upload",
+		Format:     "text",
+		SourceID:   sourceID,
+		Source:     sourceName,
+		SourceType: sourceType,
+	}
 
 	fmt.Println("Len of highlights text area:", len(strings.TrimSpace(highlightsText)))
 
 	if strings.TrimSpace(highlightsText) != "" {
 		fmt.Println("Processing highlights from text area")
-		lines = strings.Split(highlightsText, "\n")
+		entries = splitting.Split(highlightsText)
 	} else {
 		fmt.Println("Processing highlights from uploaded file")
-		file, _, err := r.FormFile("highlights_file")
+		file, header, err := r.FormFile("highlights_file")
 
 		if err != nil {
 			http.Error(w, "Failed to read file", http.StatusBadRequest)
@@ -165,6 +395,8 @@ func (h *Handlers) AddHighlights(w http.ResponseWriter, r *http.Request) {
 		}
 
 		defer file.Close()
+		batch.Format = "file"
+		batch.FileName = header.Filename
 
 		content, err := io.ReadAll(file)
 		if err != nil {
@@ -172,56 +404,75 @@ func (h *Handlers) AddHighlights(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		lines = strings.Split(string(content), "\n")
+		entries = splitting.Split(string(content))
 	}
 
-	var highlights []models.Highlight
+	highlights, failed := internal.ParseHighlights(entries, splitting, sourceName, sourceType)
+	highlights, emptied := h.Normalizer.Highlights(highlights)
+	failed = append(failed, emptied...)
+	batch.Failed = len(failed)
 
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		trimmed_line := strings.TrimSpace(line)
-		highlight := models.Highlight{
-			Source:     sourceName,
-			SourceType: sourceType,
-			Content:    trimmed_line,
-		}
-		highlights = append(highlights, highlight)
+	if r.FormValue("preview") != "" {
+		h.previewImport(w, batch, highlights, failed)
+		return
 	}
 
-	// Insert highlights into the database
-	count, err := h.DB.InsertHighlights(highlights)
-	if err != nil {
-		http.Error(w, "Failed to insert highlights into database", http.StatusInternalServerError)
+	if len(highlights) == 0 {
+		http.Error(w, "No highlights found to import", http.StatusBadRequest)
 		return
 	}
 
-	// Insert highlights into the FTS table
-	log.Println("Inserting highlights into FTS table...")
-	err = h.Search.InsertToFTS(highlights, sourceName)
+	if batch.SourceID == 0 {
+		batch.SourceID, err = h.DB.CreateSource(sourceName, sourceType)
+		if err != nil {
+			http.Error(w, "Failed to create source", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Insert highlights into the database as one import batch; triggers
+	// add them to the search indexes in the same transaction
+	err = h.DB.ImportHighlights(batch, highlights)
 	if err != nil {
-		http.Error(w, "Failed to insert highlights into search index", http.StatusInternalServerError)
+		http.Error(w, "Failed to insert highlights into database", http.StatusInternalServerError)
 		return
 	}
-	log.Println("Highlights successfully inserted into FTS table.")
-
-	// Write highlights to a file for backup
-	backupFilePath := fmt.Sprintf("backups/%s/%s_highlights.txt", sourceType, sourceName)
-	err = internal.WriteHighlightsToFile(highlights, backupFilePath)
 
+	// Rewrite the source's backup file from the database so it holds every
+	// highlight of the source, not just this upload
+	err = internal.RewriteBackup(h.DB, batch.SourceID)
 	if err != nil {
 		log.Println("Error writing highlights to backup file:", err)
 		http.Error(w, "Failed to write highlights to backup file", http.StatusInternalServerError)
 		return
 	}
 
+	notes := ""
+	if batch.Skipped > 0 {
+		notes += fmt.Sprintf(", skipped %d already imported", batch.Skipped)
+	}
+	if batch.Failed > 0 {
+		notes += fmt.Sprintf(", %d malformed lines ignored", batch.Failed)
+	}
+	// Nothing to undo when every highlight was already imported
+	undo := ""
+	if batch.Inserted > 0 {
+		undo = fmt.Sprintf(`
+			<button
+				hx-post="/admin/imports/%s/undo"
+				hx-confirm="Remove the %d highlights added by this import?"
+				hx-target="closest div"
+				hx-swap="outerHTML"
+				class="ml-2 underline font-medium hover:text-green-900">
+				Undo this import
+			</button>`, batch.ID, batch.Inserted)
+	}
 	response := fmt.Sprintf(`
 		<div class="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded relative" role="alert">
 			<strong class="font-bold">Success!</strong>
-			<span class="block sm:inline">Uploaded %d highlights for "%s"</span>
+			<span class="block sm:inline">Uploaded %d highlights for "%s"%s</span>%s
 		</div>
-	`, count, sourceName)
+	`, batch.Inserted, template.HTMLEscapeString(sourceName), notes, undo)
 
 	w.Header().Set("Content-Type", "text/html")
 	w.Write([]byte(response))
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"highlights-anki/internal"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+)
+
+// importHistoryLimit is how many past imports the history lists.
+const importHistoryLimit = 50
+
+// previewImport renders what importing highlights into batch.SourceID
+// would do, without importing them.
+func (h *Handlers) previewImport(w http.ResponseWriter, batch *models.ImportBatch, highlights []models.Highlight, failed []string) {
+	skipped, err := h.DB.PreviewImport(batch.SourceID, highlights)
+	if err != nil {
+		http.Error(w, "Failed to preview import", http.StatusInternalServerError)
+		return
+	}
+	for _, skip := range skipped {
+		if skip {
+			batch.Skipped++
+		} else {
+			batch.Inserted++
+		}
+	}
+
+	preview := models.ImportPreview{Batch: *batch, Highlights: highlights, Skipped: skipped, Failed: failed}
+	err = h.tmpl.ExecuteTemplate(w, "import-preview.html", preview)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ImportsHandler serves GET /admin/imports, the history of imports.
+func (h *Handlers) ImportsHandler(w http.ResponseWriter, r *http.Request) {
+	batches, err := h.DB.GetImportBatches(importHistoryLimit)
+	if err != nil {
+		http.Error(w, "Failed to fetch import history", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "imports.html", batches)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ImportHighlightsHandler serves GET /admin/imports/{id}, the highlights
+// an import added that are still in the library.
+func (h *Handlers) ImportHighlightsHandler(w http.ResponseWriter, r *http.Request) {
+	highlights, err := h.DB.GetBatchHighlights(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to fetch import highlights", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "highlights.html", highlights)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UndoImportHandler serves POST /admin/imports/{id}/undo, removing the
+// highlights an import added.
+func (h *Handlers) UndoImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, err := internal.UndoImport(h.DB, r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to undo import", http.StatusInternalServerError)
+		return
+	}
+
+	response := fmt.Sprintf(`
+		<div class="bg-gray-100 border border-gray-300 text-gray-700 px-4 py-3 rounded relative" role="alert">
+			Import undone: removed %d highlights
+		</div>
+	`, count)
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(response))
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"highlights-anki/internal"
+	"highlights-anki/internal/database"
+	"highlights-anki/internal/metadata"
+	"highlights-anki/internal/models"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MetadataHandler serves GET /admin/metadata, the sources that can be
+// looked up with the metadata provider.
+func (h *Handlers) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	sources, err := h.DB.GetSources(database.HighlightFilter{})
+	if err != nil {
+		http.Error(w, "Failed to fetch sources", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "metadata.html", sources)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// MetadataLookupHandler serves GET /admin/metadata/lookup?source_id=...,
+// the provider's proposals for a source, each ready to review and apply.
+func (h *Handlers) MetadataLookupHandler(w http.ResponseWriter, r *http.Request) {
+	source, ok := h.formSource(w, r)
+	if !ok {
+		return
+	}
+
+	query := metadata.Query{Title: source.Name, ISBN: source.ISBN}
+	if len(source.Authors) > 0 {
+		query.Author = source.Authors[0].Name
+	}
+	proposals, err := h.Metadata.Lookup(r.Context(), query)
+	if err != nil {
+		log.Println("[metadata.go] Error looking up metadata:", source.Name, err)
+		response := fmt.Sprintf(`
+		<div class="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded" role="alert">
+			%s lookup failed: %s
+		</div>
+	`, h.Metadata.Name(), template.HTMLEscapeString(err.Error()))
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(response))
+		return
+	}
+
+	data := struct {
+		Source    models.Source
+		Provider  string
+		Proposals []metadata.Proposal
+	}{source, h.Metadata.Name(), proposals}
+	err = h.tmpl.ExecuteTemplate(w, "metadata-proposals", data)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// MetadataApplyHandler serves POST /admin/metadata/apply, saving a
+// reviewed proposal as the metadata of the "source_id" form value. The cover
+// is downloaded when "use_cover" is set, and the "subject" values are added
+// to the source's tags.
+func (h *Handlers) MetadataApplyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	stored, ok := h.formSource(w, r)
+	if !ok {
+		return
+	}
+	source := models.Source{
+		ID:          stored.ID,
+		ISBN:        r.FormValue("isbn"),
+		URL:         r.FormValue("url"),
+		Description: r.FormValue("description"),
+		Language:    r.FormValue("language"),
+	}
+	if year := strings.TrimSpace(r.FormValue("year")); year != "" {
+		var err error
+		source.Year, err = strconv.Atoi(year)
+		if err != nil {
+			http.Error(w, "Year must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.DB.SetSourceMetadata(source, []string{r.FormValue("authors")}); err != nil {
+		http.Error(w, "Failed to save source", http.StatusInternalServerError)
+		return
+	}
+
+	if coverURL := r.FormValue("cover_url"); coverURL != "" && r.FormValue("use_cover") != "" {
+		data, err := h.Metadata.FetchCover(r.Context(), coverURL)
+		if errors.Is(err, metadata.ErrForeignCover) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("[metadata.go] Error fetching cover:", coverURL, err)
+			http.Error(w, "Failed to download cover", http.StatusBadGateway)
+			return
+		}
+		cover, err := internal.SaveCover(data)
+		if err != nil {
+			log.Println("[metadata.go] Error saving cover:", err)
+			http.Error(w, "Failed to save cover", http.StatusInternalServerError)
+			return
+		}
+		if err := h.DB.SetSourceCover(source.ID, cover); err != nil {
+			http.Error(w, "Failed to save cover", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if subjects := r.Form["subject"]; len(subjects) > 0 {
+		tags, err := h.DB.GetSourceTags(source.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+			return
+		}
+		if err := h.DB.SetSourceTags(source.ID, append(tags, subjects...)); err != nil {
+			http.Error(w, "Failed to save tags", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := fmt.Sprintf(`
+		<div class="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded relative" role="alert">
+			Saved details of %s
+		</div>
+	`, template.HTMLEscapeString(stored.Name))
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(response))
+}
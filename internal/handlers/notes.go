@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// HighlightNoteHandler serves POST /highlights/{id}/note, replacing the
+// highlight's note with the "note" form value. An empty value removes it.
+func (h *Handlers) HighlightNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.SetHighlightNote(id, r.FormValue("note"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save note", http.StatusInternalServerError)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "highlight-note", highlight)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
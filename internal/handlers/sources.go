@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal"
+	"highlights-anki/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// formSource returns the source named by the "source_id" form value,
+// responding with an error and returning false if it is invalid or the
+// source has no highlights.
+func (h *Handlers) formSource(w http.ResponseWriter, r *http.Request) (models.Source, bool) {
+	id, err := strconv.Atoi(r.FormValue("source_id"))
+	if err != nil {
+		http.Error(w, "Invalid source id", http.StatusBadRequest)
+		return models.Source{}, false
+	}
+	source, err := h.DB.GetSource(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return source, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch source", http.StatusInternalServerError)
+		return source, false
+	}
+	return source, true
+}
+
+// renderSource renders the source page: its metadata header, the episodes
+// of a source type with feeds, and its highlights grouped by chapter in
+// reading order.
+func (h *Handlers) renderSource(w http.ResponseWriter, r *http.Request, id int) {
+	source, err := h.DB.GetSource(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch source", http.StatusInternalServerError)
+		return
+	}
+
+	highlights, err := h.DB.GetSourceHighlights(id)
+	if err != nil {
+		log.Printf("Error fetching source highlights: %v", err)
+		http.Error(w, "Failed to fetch source highlights", http.StatusInternalServerError)
+		return
+	}
+
+	var episodes []models.Episode
+	if h.DB.LookupSourceType(source.Type).Has("feed") {
+		episodes, err = h.DB.GetEpisodes(id)
+		if err != nil {
+			http.Error(w, "Failed to fetch episodes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pinned, rest := models.SplitPinned(highlights)
+	data := struct {
+		Source   models.Source
+		Episodes []models.Episode
+		Pinned   []models.Highlight
+		Chapters []models.Chapter
+	}{source, episodes, pinned, models.GroupChapters(rest)}
+	err = h.tmpl.ExecuteTemplate(w, "source.html", data)
+	if err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SourceMetadataHandler serves POST /sources/metadata, saving the metadata
+// and optionally the cover image of the source with the "source_id" form
+// value, and re-renders the source page.
+func (h *Handlers) SourceMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(internal.MaxCoverSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	stored, ok := h.formSource(w, r)
+	if !ok {
+		return
+	}
+	source := models.Source{
+		ID:          stored.ID,
+		ISBN:        r.FormValue("isbn"),
+		URL:         r.FormValue("url"),
+		Description: r.FormValue("description"),
+		Language:    r.FormValue("language"),
+	}
+	if year := strings.TrimSpace(r.FormValue("year")); year != "" {
+		var err error
+		source.Year, err = strconv.Atoi(year)
+		if err != nil {
+			http.Error(w, "Year must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fields the source's type does not offer keep what was saved before
+	sourceType := h.DB.LookupSourceType(stored.Type)
+	authors := []string{r.FormValue("authors")}
+	if !sourceType.Has("authors") {
+		authors = nil
+		for _, author := range stored.Authors {
+			authors = append(authors, author.Name)
+		}
+	}
+	if !sourceType.Has("year") {
+		source.Year = stored.Year
+	}
+	if !sourceType.Has("isbn") {
+		source.ISBN = stored.ISBN
+	}
+	if !sourceType.Has("language") {
+		source.Language = stored.Language
+	}
+	if !sourceType.Has("url") {
+		source.URL = stored.URL
+	}
+	if !sourceType.Has("description") {
+		source.Description = stored.Description
+	}
+
+	if err := h.DB.SetSourceMetadata(source, authors); err != nil {
+		http.Error(w, "Failed to save source", http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("remove_cover") != "" {
+		if err := h.DB.SetSourceCover(source.ID, ""); err != nil {
+			http.Error(w, "Failed to remove cover", http.StatusInternalServerError)
+			return
+		}
+	} else if file, _, err := r.FormFile("cover"); err == nil {
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, internal.MaxCoverSize+1))
+		if err != nil {
+			http.Error(w, "Failed to read cover", http.StatusBadRequest)
+			return
+		}
+		if len(data) > internal.MaxCoverSize {
+			http.Error(w, "Cover image is too large (5 MB at most)", http.StatusRequestEntityTooLarge)
+			return
+		}
+		cover, err := internal.SaveCover(data)
+		if errors.Is(err, internal.ErrCoverType) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("[sources.go] Error saving cover:", err)
+			http.Error(w, "Failed to save cover", http.StatusInternalServerError)
+			return
+		}
+		if err := h.DB.SetSourceCover(source.ID, cover); err != nil {
+			http.Error(w, "Failed to save cover", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.renderSource(w, r, source.ID)
+}
+
+// CoverHandler serves GET /covers/{file}, an uploaded source cover.
+func (h *Handlers) CoverHandler(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.PathValue("file"))
+	if name == "." || name == "/" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(internal.CoversFolder, name))
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// sourceTypeName is what a source type may be called. Names are also
+// backup folder names, so they are kept to lowercase letters, digits and
+// hyphens.
+var sourceTypeName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// SourceTypesHandler serves /admin/source-types: GET lists the source types
+// with a form to add one, and POST adds or updates the type described by
+// the "name", "icon", "color" and "field" form values.
+func (h *Handlers) SourceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		t := models.SourceType{
+			Name:  strings.ToLower(strings.TrimSpace(r.FormValue("name"))),
+			Icon:  strings.TrimSpace(r.FormValue("icon")),
+			Color: r.FormValue("color"),
+		}
+		if !sourceTypeName.MatchString(t.Name) {
+			http.Error(w, "Type names may only have lowercase letters, digits and hyphens", http.StatusBadRequest)
+			return
+		}
+		if t.Icon == "" {
+			t.Icon = "📄"
+		}
+		if !slices.Contains(models.SourceColors, t.Color) {
+			http.Error(w, "Unknown color", http.StatusBadRequest)
+			return
+		}
+		for _, field := range models.SourceFields {
+			if slices.Contains(r.Form["field"], field) {
+				t.Fields = append(t.Fields, field)
+			}
+		}
+		if err := h.DB.SaveSourceType(t); err != nil {
+			http.Error(w, "Failed to save source type", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderSourceTypes(w, "")
+}
+
+// DeleteSourceTypeHandler serves POST /admin/source-types/delete, removing
+// the source type named by the "name" form value if no source has it.
+func (h *Handlers) DeleteSourceTypeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.FormValue("name")
+	deleted, err := h.DB.DeleteSourceType(name)
+	if err != nil {
+		http.Error(w, "Failed to delete source type", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		h.renderSourceTypes(w, fmt.Sprintf("Sources still have the type %q, so it was kept.", name))
+		return
+	}
+	h.renderSourceTypes(w, "")
+}
+
+// SourceTypeOptionsHandler serves GET /source-types/options, the source
+// types as options of a select.
+func (h *Handlers) SourceTypeOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.tmpl.ExecuteTemplate(w, "source-type-options", h.DB.SourceTypes())
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// renderSourceTypes renders the source types for editing, with message
+// shown above them when set.
+func (h *Handlers) renderSourceTypes(w http.ResponseWriter, message string) {
+	types, err := h.DB.GetSourceTypes()
+	if err != nil {
+		http.Error(w, "Failed to fetch source types", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Types   []models.SourceType
+		Fields  []string
+		Colors  []string
+		Message string
+	}{types, models.SourceFields, models.SourceColors, message}
+	err = h.tmpl.ExecuteTemplate(w, "source-types.html", data)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"highlights-anki/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// TagsHandler serves GET /tags, the tag cloud.
+func (h *Handlers) TagsHandler(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.DB.GetTagCounts()
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "tags.html", counts)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// HighlightTagsHandler serves POST /highlights/{id}/tags, replacing the
+// highlight's tags with the comma separated "tags" form value.
+func (h *Handlers) HighlightTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid highlight id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.SetHighlightTags(id, []string{r.FormValue("tags")})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save tags", http.StatusInternalServerError)
+		return
+	}
+
+	highlight, err := h.DB.GetHighlight(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch highlight", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "highlight-tags", highlight)
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SourceTagsHandler serves POST /sources/tags, replacing the tags of the
+// source with the "source_id" form value.
+func (h *Handlers) SourceTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("source_id"))
+	if err != nil {
+		http.Error(w, "Invalid source id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.DB.SetSourceTags(id, []string{r.FormValue("tags")})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save tags", http.StatusInternalServerError)
+		return
+	}
+	tags, err := h.DB.GetSourceTags(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "source-tags", models.Source{ID: id, Tags: tags})
+	if err != nil {
+		log.Println("Error executing template:", err)
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,30 @@
+// Package markdown renders the Markdown users write in notes.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// md leaves goldmark's default of dropping raw HTML in place, so rendered
+// notes are safe to put on the page as they are.
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// Render converts Markdown source to HTML. Raw HTML in the source is
+// omitted and unsafe link targets such as javascript: are dropped.
+func Render(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(source), &buf); err != nil {
+		log.Println("[markdown.go] Error rendering Markdown:", err)
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,44 @@
+// Package metadata looks up details of books and other sources from
+// online catalogues, so they need not be typed in by hand.
+package metadata
+
+import (
+	"context"
+	"errors"
+)
+
+// Query describes the source to look up. ISBN is used when set, otherwise
+// Title and, to narrow it down, Author.
+type Query struct {
+	Title  string
+	Author string
+	ISBN   string
+}
+
+// Proposal is what a provider found for a query. Fields it could not fill
+// are empty.
+type Proposal struct {
+	Title    string
+	Authors  []string
+	Year     int
+	ISBN     string
+	URL      string
+	Language string
+	Subjects []string
+	CoverURL string
+}
+
+// Provider looks up source metadata in one catalogue.
+type Provider interface {
+	// Name is shown next to proposals, e.g. "Open Library".
+	Name() string
+	// Lookup returns the best matches for query, best first.
+	Lookup(ctx context.Context, query Query) ([]Proposal, error)
+	// FetchCover downloads a cover from a Proposal's CoverURL. It refuses
+	// URLs that did not come from the provider.
+	FetchCover(ctx context.Context, url string) ([]byte, error)
+}
+
+// ErrForeignCover is returned by FetchCover for a URL outside the
+// provider's cover service.
+var ErrForeignCover = errors.New("cover URL is not from the metadata provider")
@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default Open Library endpoints, overridable to test against a stub.
+const (
+	DefaultOpenLibraryURL       = "https://openlibrary.org"
+	DefaultOpenLibraryCoversURL = "https://covers.openlibrary.org"
+)
+
+// maxProposals is how many search results are proposed.
+const maxProposals = 5
+
+// maxSubjects is how many subjects of a work are proposed as tags.
+const maxSubjects = 5
+
+// maxCoverSize is the largest cover FetchCover downloads, in bytes.
+const maxCoverSize = 5 << 20
+
+// OpenLibrary looks up books with the Open Library search API.
+type OpenLibrary struct {
+	BaseURL   string
+	CoversURL string
+	Client    *http.Client
+}
+
+// NewOpenLibrary returns a provider for the Open Library at baseURL and
+// its cover service at coversURL; empty values use the public service.
+func NewOpenLibrary(baseURL, coversURL string) *OpenLibrary {
+	if baseURL == "" {
+		baseURL = DefaultOpenLibraryURL
+	}
+	if coversURL == "" {
+		coversURL = DefaultOpenLibraryCoversURL
+	}
+	return &OpenLibrary{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		CoversURL: strings.TrimSuffix(coversURL, "/"),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (ol *OpenLibrary) Name() string {
+	return "Open Library"
+}
+
+// openLibraryDoc is one result of /search.json, limited to the fields
+// requested.
+type openLibraryDoc struct {
+	Key              string   `json:"key"`
+	Title            string   `json:"title"`
+	AuthorName       []string `json:"author_name"`
+	FirstPublishYear int      `json:"first_publish_year"`
+	ISBN             []string `json:"isbn"`
+	Language         []string `json:"language"`
+	Subject          []string `json:"subject"`
+	CoverID          int      `json:"cover_i"`
+}
+
+func (ol *OpenLibrary) Lookup(ctx context.Context, query Query) ([]Proposal, error) {
+	params := url.Values{}
+	if query.ISBN != "" {
+		params.Set("isbn", query.ISBN)
+	} else {
+		params.Set("title", query.Title)
+		if query.Author != "" {
+			params.Set("author", query.Author)
+		}
+	}
+	params.Set("fields", "key,title,author_name,first_publish_year,isbn,language,subject,cover_i")
+	params.Set("limit", fmt.Sprint(maxProposals))
+
+	var result struct {
+		Docs []openLibraryDoc `json:"docs"`
+	}
+	if err := ol.getJSON(ctx, ol.BaseURL+"/search.json?"+params.Encode(), &result); err != nil {
+		return nil, err
+	}
+
+	var proposals []Proposal
+	for _, doc := range result.Docs {
+		proposal := Proposal{
+			Title:   doc.Title,
+			Authors: doc.AuthorName,
+			Year:    doc.FirstPublishYear,
+			ISBN:    query.ISBN,
+		}
+		if proposal.ISBN == "" && len(doc.ISBN) > 0 {
+			proposal.ISBN = doc.ISBN[0]
+		}
+		if doc.Key != "" {
+			proposal.URL = ol.BaseURL + doc.Key
+		}
+		if len(doc.Language) > 0 {
+			proposal.Language = doc.Language[0]
+		}
+		proposal.Subjects = doc.Subject[:min(len(doc.Subject), maxSubjects)]
+		if doc.CoverID != 0 {
+			proposal.CoverURL = fmt.Sprintf("%s/b/id/%d-L.jpg", ol.CoversURL, doc.CoverID)
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals, nil
+}
+
+func (ol *OpenLibrary) FetchCover(ctx context.Context, coverURL string) ([]byte, error) {
+	if !strings.HasPrefix(coverURL, ol.CoversURL+"/") {
+		return nil, ErrForeignCover
+	}
+	body, err := ol.get(ctx, coverURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxCoverSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCoverSize {
+		return nil, fmt.Errorf("cover from %s is larger than %d bytes", coverURL, maxCoverSize)
+	}
+	return data, nil
+}
+
+func (ol *OpenLibrary) getJSON(ctx context.Context, url string, v any) error {
+	body, err := ol.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+func (ol *OpenLibrary) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ol.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
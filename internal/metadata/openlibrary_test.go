@@ -0,0 +1,124 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenLibraryLookup(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if r.URL.Path != "/search.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"docs": [
+			{"key": "/works/OL1W", "title": "Atomic Habits", "author_name": ["James Clear"],
+			 "first_publish_year": 2018, "isbn": ["9780735211292", "0735211299"], "language": ["eng"],
+			 "subject": ["Habits", "Self-help", "Success", "Behavior", "Psychology", "Change"], "cover_i": 42},
+			{"title": "Atomic Habits Workbook"}
+		]}`))
+	}))
+	defer server.Close()
+
+	ol := NewOpenLibrary(server.URL, server.URL+"/covers")
+	proposals, err := ol.Lookup(context.Background(), Query{Title: "Atomic Habits", Author: "James Clear"})
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+
+	if q := got.URL.Query(); q.Get("title") != "Atomic Habits" || q.Get("author") != "James Clear" || q.Has("isbn") {
+		t.Errorf("searched with %s, want title and author", got.URL.RawQuery)
+	}
+	want := []Proposal{
+		{
+			Title:    "Atomic Habits",
+			Authors:  []string{"James Clear"},
+			Year:     2018,
+			ISBN:     "9780735211292",
+			URL:      server.URL + "/works/OL1W",
+			Language: "eng",
+			Subjects: []string{"Habits", "Self-help", "Success", "Behavior", "Psychology"},
+			CoverURL: server.URL + "/covers/b/id/42-L.jpg",
+		},
+		{Title: "Atomic Habits Workbook"},
+	}
+	if !reflect.DeepEqual(proposals, want) {
+		t.Errorf("got %+v\nwant %+v", proposals, want)
+	}
+}
+
+func TestOpenLibraryLookupByISBN(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"docs": [{"title": "Atomic Habits", "isbn": ["0735211299"]}]}`))
+	}))
+	defer server.Close()
+
+	proposals, err := NewOpenLibrary(server.URL, "").Lookup(context.Background(), Query{Title: "Atomic Habits", ISBN: "9780735211292"})
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if !strings.Contains(query, "isbn=9780735211292") || strings.Contains(query, "title=") {
+		t.Errorf("searched with %s, want only the ISBN", query)
+	}
+	// The ISBN asked for is kept over the first one of the work
+	if len(proposals) != 1 || proposals[0].ISBN != "9780735211292" {
+		t.Errorf("got %+v, want one proposal with the queried ISBN", proposals)
+	}
+}
+
+func TestOpenLibraryLookupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"not found", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		}},
+		{"not JSON", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			proposals, err := NewOpenLibrary(server.URL, "").Lookup(context.Background(), Query{Title: "Atomic Habits"})
+			if err == nil {
+				t.Fatalf("got %+v, want an error", proposals)
+			}
+		})
+	}
+}
+
+func TestOpenLibraryFetchCover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/b/id/42-L.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("jpeg"))
+	}))
+	defer server.Close()
+
+	ol := NewOpenLibrary("", server.URL)
+	data, err := ol.FetchCover(context.Background(), server.URL+"/b/id/42-L.jpg")
+	if err != nil || string(data) != "jpeg" {
+		t.Errorf("FetchCover = %q, %v; want the cover", data, err)
+	}
+	if _, err := ol.FetchCover(context.Background(), server.URL+"/b/id/7-L.jpg"); err == nil {
+		t.Error("FetchCover of a missing cover succeeded")
+	}
+	if _, err := ol.FetchCover(context.Background(), "https://example.com/cover.jpg"); !errors.Is(err, ErrForeignCover) {
+		t.Errorf("FetchCover from another host = %v, want ErrForeignCover", err)
+	}
+}
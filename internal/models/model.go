@@ -1,12 +1,314 @@
 package models
 
+import (
+	"fmt"
+	"html/template"
+	"slices"
+	"time"
+)
+
+// Highlight is one highlight and where it came from: SourceID is its
+// source, whose name and type Source and SourceType repeat. The time
+// fields are zero when unknown: CreatedAt for highlights imported before
+// it was recorded, HighlightedAt when the import format had no date.
+// RawContent is the text as imported, set only when normalizing changed
+// it. Note is the user's own Markdown commentary, kept apart from the
+// quoted Content.
 type Highlight struct {
-	Source     string
-	SourceType string
-	Content    string
+	ID            int       `json:"id"`
+	SourceID      int       `json:"source_id"`
+	Source        string    `json:"source"`
+	SourceType    string    `json:"source_type"`
+	Content       string    `json:"content"`
+	RawContent    string    `json:"raw_content,omitempty"`
+	Note          string    `json:"note,omitempty"`
+	CreatedAt     time.Time `json:"created_at,omitzero"`
+	UpdatedAt     time.Time `json:"updated_at,omitzero"`
+	HighlightedAt time.Time `json:"highlighted_at,omitzero"`
+	ImportBatch   string    `json:"import_batch,omitempty"`
+	Importer      string    `json:"importer,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	// EpisodeID links a podcast highlight to the episode it was heard in,
+	// Timestamp is where in it, in seconds. Episode is filled in when
+	// EpisodeID is set.
+	EpisodeID int      `json:"episode_id,omitempty"`
+	Episode   *Episode `json:"episode,omitempty"`
+	Timestamp int      `json:"timestamp,omitempty"`
+	// Chapter is the chapter or section the highlight is in. Location,
+	// Page and Percent place it within a book, zero when unknown.
+	Chapter  string `json:"chapter,omitempty"`
+	Location int    `json:"location,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Percent  int    `json:"percent,omitempty"`
+	// Color is the highlighter color, one of HighlightColors, and Kind one
+	// of HighlightKinds; both are empty when not given.
+	Color string `json:"color,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	// Favorite highlights are starred, Pinned ones are kept on top of their
+	// source, and Suspended ones are left out of review.
+	Favorite  bool `json:"favorite,omitempty"`
+	Pinned    bool `json:"pinned,omitempty"`
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// HighlightColors are the highlighter colors a highlight can have, named
+// as Tailwind colors.
+var HighlightColors = []string{"yellow", "orange", "red", "pink", "purple", "blue", "green"}
+
+// HighlightKinds are what an imported entry can be: a quoted passage, the
+// reader's own note, or a bookmark. An empty Kind is a quote.
+var HighlightKinds = []string{"quote", "note", "bookmark"}
+
+// ColorChoices returns the colors the highlight can be given.
+func (h Highlight) ColorChoices() []string {
+	return HighlightColors
+}
+
+// HighlightFlags are the states a highlight can be put in, named as its
+// fields in lowercase.
+var HighlightFlags = []string{"favorite", "pinned", "suspended"}
+
+// Original is the text of the highlight as it was imported.
+func (h Highlight) Original() string {
+	if h.RawContent != "" {
+		return h.RawContent
+	}
+	return h.Content
+}
+
+// TimestampLabel is Timestamp as "34:12", or "1:02:03" past an hour.
+func (h Highlight) TimestampLabel() string {
+	return FormatDuration(h.Timestamp)
+}
+
+// PositionLabel is where the highlight is within its source, as "loc.
+// 1234", "p. 42", "42%" or a timestamp, or empty when unknown. When several
+// are known it is the first of PositionLabels.
+func (h Highlight) PositionLabel() string {
+	if labels := h.PositionLabels(); len(labels) > 0 {
+		return labels[0]
+	}
+	return ""
+}
+
+// PositionLabels are all the known positions of the highlight, in the
+// order location, page, percent and timestamp.
+func (h Highlight) PositionLabels() []string {
+	var labels []string
+	if h.Location != 0 {
+		labels = append(labels, fmt.Sprintf("loc. %d", h.Location))
+	}
+	if h.Page != 0 {
+		labels = append(labels, fmt.Sprintf("p. %d", h.Page))
+	}
+	if h.Percent != 0 {
+		labels = append(labels, fmt.Sprintf("%d%%", h.Percent))
+	}
+	if h.Timestamp != 0 {
+		labels = append(labels, h.TimestampLabel())
+	}
+	return labels
+}
+
+// Chapter is a run of a source's highlights that share a chapter, in
+// reading order. Title is empty for highlights with no chapter.
+type Chapter struct {
+	Title      string
+	Highlights []Highlight
+}
+
+// GroupChapters groups highlights by chapter. Chapters are ordered by
+// their first highlight, and highlights keep their order within a chapter.
+func GroupChapters(highlights []Highlight) []Chapter {
+	var chapters []Chapter
+	index := map[string]int{}
+	for _, highlight := range highlights {
+		i, ok := index[highlight.Chapter]
+		if !ok {
+			i = len(chapters)
+			index[highlight.Chapter] = i
+			chapters = append(chapters, Chapter{Title: highlight.Chapter})
+		}
+		chapters[i].Highlights = append(chapters[i].Highlights, highlight)
+	}
+	return chapters
+}
+
+// SplitPinned separates the pinned highlights from the others, keeping
+// their order.
+func SplitPinned(highlights []Highlight) (pinned, rest []Highlight) {
+	for _, highlight := range highlights {
+		if highlight.Pinned {
+			pinned = append(pinned, highlight)
+		} else {
+			rest = append(rest, highlight)
+		}
+	}
+	return pinned, rest
+}
+
+// FormatDuration formats seconds as minutes:seconds, with hours in front
+// when there are any.
+func FormatDuration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Episode is one episode of a podcast source, read from its RSS feed.
+// Duration is in seconds, zero when the feed does not say.
+type Episode struct {
+	ID          int       `json:"id"`
+	SourceID    int       `json:"source_id"`
+	GUID        string    `json:"guid"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at,omitzero"`
+	AudioURL    string    `json:"audio_url,omitempty"`
+	Duration    int       `json:"duration,omitempty"`
+}
+
+// DurationLabel is Duration formatted like Highlight.TimestampLabel.
+func (e Episode) DurationLabel() string {
+	return FormatDuration(e.Duration)
 }
 
+// ImportBatch records one import: who made it, from what, and what came of
+// each line. UndoneAt is set once the import has been undone.
+type ImportBatch struct {
+	ID         string    `json:"id"`
+	Importer   string    `json:"importer"`
+	Format     string    `json:"format"`
+	FileName   string    `json:"file_name,omitempty"`
+	SourceID   int       `json:"source_id"`
+	Source     string    `json:"source"`
+	SourceType string    `json:"source_type"`
+	CreatedAt  time.Time `json:"created_at,omitzero"`
+	Inserted   int       `json:"inserted"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	UndoneAt   time.Time `json:"undone_at,omitzero"`
+}
+
+// ImportPreview is what an import would do, shown before it is committed.
+// Skipped[i] reports whether Highlights[i] is already in the library or
+// earlier in the same import.
+type ImportPreview struct {
+	Batch      ImportBatch
+	Highlights []Highlight
+	Skipped    []bool
+	Failed     []string
+}
+
+// Source is a book, podcast or other work highlights come from. Sources
+// may share a name, such as two editions of a book; NameIndex numbers
+// those of the same name and type from 1, oldest first. The metadata
+// fields are empty until the user fills them in; Cover is the file name of
+// the uploaded cover under covers/, and FeedURL the RSS feed episodes of a
+// podcast were last read from.
 type Source struct {
-	Name string
-	Type string
+	ID          int
+	Name        string
+	Type        string
+	NameIndex   int
+	Authors     []Author
+	Year        int
+	ISBN        string
+	URL         string
+	Description string
+	Language    string
+	Cover       string
+	FeedURL     string
+	Tags        []string
+}
+
+// SourceFields are the metadata fields a source type can ask for: the
+// fields of Source, with "feed" for reading episodes from an RSS feed.
+var SourceFields = []string{"authors", "year", "isbn", "language", "url", "description", "cover", "feed"}
+
+// SourceColors are the Tailwind color names a source type can use.
+var SourceColors = []string{"gray", "red", "orange", "amber", "yellow", "lime", "green", "emerald", "teal", "cyan",
+	"sky", "blue", "indigo", "violet", "purple", "fuchsia", "pink", "rose"}
+
+// SourceType is a kind of source, such as book or podcast, and how its
+// sources are shown: Icon is an emoji, Color one of SourceColors, and
+// Fields the SourceFields its sources have.
+type SourceType struct {
+	Name   string   `json:"name"`
+	Icon   string   `json:"icon"`
+	Color  string   `json:"color"`
+	Fields []string `json:"fields"`
+	// Sources counts the sources of this type, where listed for editing.
+	Sources int `json:"sources,omitempty"`
+}
+
+// Has reports whether sources of the type have the given metadata field.
+func (t SourceType) Has(field string) bool {
+	return slices.Contains(t.Fields, field)
+}
+
+// DefaultSourceType is how sources of a type that is not registered, such
+// as one removed after import, are shown: plainly, with every field.
+func DefaultSourceType(name string) SourceType {
+	return SourceType{Name: name, Icon: "📄", Color: "gray", Fields: SourceFields}
+}
+
+// Author wrote one or more sources. Sources and Highlights count what the
+// library holds by them, and are zero where only the name is needed.
+type Author struct {
+	ID         int
+	Name       string
+	Sources    int
+	Highlights int
+}
+
+// TagCount is a tag and how many highlights and sources carry it.
+type TagCount struct {
+	Name       string `json:"name"`
+	Highlights int    `json:"highlights"`
+	Sources    int    `json:"sources"`
+}
+
+// Total is the number of highlights and sources tagged.
+func (t TagCount) Total() int {
+	return t.Highlights + t.Sources
+}
+
+// SearchResult is a highlight matched by full-text search. Snippet,
+// Highlighted and NoteHighlighted are HTML-escaped with the matched terms
+// wrapped in <mark>.
+type SearchResult struct {
+	Highlight
+	Snippet         template.HTML `json:"snippet"`
+	Highlighted     template.HTML `json:"highlighted"`
+	NoteHighlighted template.HTML `json:"note_highlighted,omitempty"`
+	Score           float64       `json:"score"`
+}
+
+// SourceFacet counts the matches of a search within one source.
+type SourceFacet struct {
+	SourceID   int    `json:"source_id"`
+	Source     string `json:"source"`
+	SourceType string `json:"source_type"`
+	Count      int    `json:"count"`
+}
+
+// SearchPage is one page of search results along with the total number of
+// matches and their breakdown by source.
+type SearchPage struct {
+	Query      string         `json:"query"`
+	Mode       string         `json:"mode"`
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	Offset     int            `json:"offset"`
+	Limit      int            `json:"limit"`
+	NextOffset int            `json:"next_offset,omitempty"`
+	Facets     []SourceFacet  `json:"facets,omitempty"`
+	// Suggestion is a spelling-corrected query, offered when nothing matched
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// HasMore reports whether there are results after this page.
+func (p *SearchPage) HasMore() bool {
+	return p.NextOffset > 0
 }
@@ -0,0 +1,157 @@
+// Package normalize cleans up the text of imported highlights. E-reader
+// exports carry typographic variants and layout artifacts that make the
+// same passage hash and index differently; a Normalizer removes them.
+package normalize
+
+import (
+	"fmt"
+	"highlights-anki/internal/models"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Steps are the names of the normalization steps, in the order they run.
+var Steps = []string{"nfc", "invisible", "ligatures", "quotes", "dashes", "hyphenation", "whitespace", "ellipses"}
+
+var (
+	invisibleReplacer = strings.NewReplacer(
+		"\u00ad", "", // soft hyphen
+		"\u200b", "", // zero width space
+		"\ufeff", "", // byte order mark
+	)
+	ligatureReplacer = strings.NewReplacer(
+		"ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi", "ﬄ", "ffl", "ﬅ", "st", "ﬆ", "st",
+	)
+	quoteReplacer = strings.NewReplacer(
+		"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+		"“", "\"", "”", "\"", "„", "\"", "‟", "\"", "″", "\"",
+	)
+	// dashReplacer turns hyphen and minus variants and en dashes into
+	// hyphen-minus, and long dashes into em dashes.
+	dashReplacer = strings.NewReplacer(
+		"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-", "\u2212", "-",
+		"\u2015", "\u2014", "\u2e3a", "\u2014", "\u2e3b", "\u2014",
+	)
+
+	// hyphenatedPattern matches a word broken with a hyphen at the end of a
+	// line, as in "exam-\nple".
+	hyphenatedPattern = regexp.MustCompile(`(\pL)-\n[ \t]*(\p{Ll})`)
+	spacePattern      = regexp.MustCompile(`[ \t\x{00a0}\x{2000}-\x{200a}\x{202f}\x{3000}]+`)
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	leadingEllipsis   = regexp.MustCompile(`^(?:\s*(?:…|\.\s*\.\s*\.\.*|\[\s*(?:…|\.\.\.)\s*\]))+\s*`)
+	trailingEllipsis  = regexp.MustCompile(`\s*(?:(?:…|\.\s*\.\s*\.\.*|\[\s*(?:…|\.\.\.)\s*\])\s*)+$`)
+)
+
+var stepFuncs = map[string]func(string) string{
+	"nfc":       norm.NFC.String,
+	"invisible": invisibleReplacer.Replace,
+	"ligatures": ligatureReplacer.Replace,
+	"quotes":    quoteReplacer.Replace,
+	"dashes":    dashReplacer.Replace,
+	"hyphenation": func(s string) string {
+		return hyphenatedPattern.ReplaceAllString(s, "$1$2")
+	},
+	"whitespace": collapseWhitespace,
+	"ellipses": func(s string) string {
+		s = leadingEllipsis.ReplaceAllString(s, "")
+		return trailingEllipsis.ReplaceAllString(s, "")
+	},
+}
+
+// collapseWhitespace turns runs of spaces into one space within lines,
+// keeping each line's indentation, drops trailing spaces and allows at
+// most one blank line in a row.
+func collapseWhitespace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		body := strings.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(body)]
+		lines[i] = indent + strings.TrimRight(spacePattern.ReplaceAllString(body, " "), " ")
+	}
+	return blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
+
+// Normalizer runs a chosen set of steps over highlight text.
+type Normalizer struct {
+	steps []string
+}
+
+// New returns a Normalizer running the named steps, which it orders as in
+// Steps.
+func New(names []string) (*Normalizer, error) {
+	n := &Normalizer{}
+	for _, name := range names {
+		if _, ok := stepFuncs[name]; !ok {
+			return nil, fmt.Errorf("unknown normalization step %q", name)
+		}
+	}
+	for _, step := range Steps {
+		for _, name := range names {
+			if name == step {
+				n.steps = append(n.steps, step)
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
+// Default returns a Normalizer running every step.
+func Default() *Normalizer {
+	return &Normalizer{steps: Steps}
+}
+
+// Parse returns a Normalizer for a comma separated list of steps. An empty
+// list runs every step, and "none" runs none.
+func Parse(list string) (*Normalizer, error) {
+	list = strings.TrimSpace(list)
+	switch list {
+	case "":
+		return New(Steps)
+	case "none":
+		return New(nil)
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return New(names)
+}
+
+// Steps returns the names of the steps n runs.
+func (n *Normalizer) Steps() []string {
+	return n.steps
+}
+
+// Normalize returns text with each step applied, trimmed.
+func (n *Normalizer) Normalize(text string) string {
+	for _, step := range n.steps {
+		text = stepFuncs[step](text)
+	}
+	return strings.TrimSpace(text)
+}
+
+// Highlights returns highlights with their content normalized, keeping
+// the original in RawContent where it changed. Highlights left with no text
+// are removed and their original text returned as failed.
+func (n *Normalizer) Highlights(highlights []models.Highlight) ([]models.Highlight, []string) {
+	var kept []models.Highlight
+	var failed []string
+	for _, highlight := range highlights {
+		content := n.Normalize(highlight.Content)
+		if content == "" {
+			failed = append(failed, highlight.Content)
+			continue
+		}
+		if content != highlight.Content {
+			highlight.RawContent = highlight.Content
+			highlight.Content = content
+		}
+		kept = append(kept, highlight)
+	}
+	return kept, failed
+}
@@ -0,0 +1,98 @@
+package normalize
+
+import (
+	"highlights-anki/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		step, text, want string
+	}{
+		{"nfc", "cafe\u0301", "café"},
+		{"invisible", "in\u00advis\u200bible\ufeff", "invisible"},
+		{"ligatures", "ﬁrst ﬂoor ﬃx", "first floor ffix"},
+		{"quotes", "“It’s ‚fine‛,” she said", "\"It's 'fine',\" she said"},
+		{"dashes", "pages 3–4 \u2212 one \u2015 or two \u2e3a", "pages 3-4 - one — or two —"},
+		{"hyphenation", "exam-\n  ple and self-\nImprovement", "example and self-\nImprovement"},
+		{"whitespace", "one  \t two\u00a0three  \n  indented line \n\n\n\nlast", "one two three\n  indented line\n\nlast"},
+		{"ellipses", "… and then... [...]", "and then"},
+		{"ellipses", "[ … ] wait... what", "wait... what"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			n, err := New([]string{tt.step})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	// Dashes run before hyphenation, so a broken word with any hyphen joins
+	text := "…  ‘ﬁnal’ exam\u2010\nple\u00ad …"
+	if got := Default().Normalize(text); got != "'final' example" {
+		t.Errorf("Normalize(%q) = %q, want %q", text, got, "'final' example")
+	}
+}
+
+func TestNew(t *testing.T) {
+	n, err := New([]string{"whitespace", "nfc", "quotes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"nfc", "quotes", "whitespace"}; !reflect.DeepEqual(n.Steps(), want) {
+		t.Errorf("Steps = %q, want %q", n.Steps(), want)
+	}
+	if _, err := New([]string{"quotes", "smart"}); err == nil {
+		t.Error("New with an unknown step succeeded")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"", Steps, false},
+		{"  ", Steps, false},
+		{"none", nil, false},
+		{" dashes , quotes,,", []string{"quotes", "dashes"}, false},
+		{"quotes,smart", nil, true},
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %t", tt.list, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(n.Steps(), tt.want) {
+			t.Errorf("Parse(%q) steps = %q, want %q", tt.list, n.Steps(), tt.want)
+		}
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	highlights := []models.Highlight{
+		{Content: "The ﬁrst  step."},
+		{Content: "Already clean."},
+		{Content: "\u200b … "},
+	}
+	kept, failed := Default().Highlights(highlights)
+	want := []models.Highlight{
+		{Content: "The first step.", RawContent: "The ﬁrst  step."},
+		{Content: "Already clean."},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %+v, want %+v", kept, want)
+	}
+	if !reflect.DeepEqual(failed, []string{"\u200b … "}) {
+		t.Errorf("failed = %q, want the highlight left empty", failed)
+	}
+}
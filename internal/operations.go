@@ -1,21 +1,25 @@
 package internal
 
 import (
-	"bufio"
+	"database/sql"
+	"errors"
 	"highlights-anki/internal/database"
 	"highlights-anki/internal/models"
+	"highlights-anki/internal/normalize"
 	"log"
 	"os"
-	"strings"
+	"path/filepath"
 )
 
 type Operations struct {
 	DB     *database.Db
 	Search *database.Search
+	// Normalizer cleans up the text of indexed highlights.
+	Normalizer *normalize.Normalizer
 }
 
 func NewOperations(db *database.Db, search *database.Search) *Operations {
-	return &Operations{DB: db, Search: search}
+	return &Operations{DB: db, Search: search, Normalizer: normalize.Default()}
 }
 
 func (op *Operations) FlushTables(tables []string) error {
@@ -28,7 +32,28 @@ func (op *Operations) FlushTables(tables []string) error {
 	return nil
 }
 
-func (op *Operations) IndexFolder(folder string) error {
+func (op *Operations) BuildSemanticIndex(dims int) error {
+	log.Println("Building semantic index with dimensions:", dims)
+	count, err := op.Search.BuildSemanticIndex(dims)
+	if err != nil {
+		return err
+	}
+	log.Printf("Embedded %d highlights into the semantic index.\n", count)
+	return nil
+}
+
+func (op *Operations) Reindex() error {
+	count, err := op.Search.Reindex()
+	if err != nil {
+		return err
+	}
+	log.Printf("Reindexed %d highlights.\n", count)
+	return nil
+}
+
+// IndexFolder imports every backup file in backups/<folder>, split into
+// highlights as splitting says.
+func (op *Operations) IndexFolder(folder string, splitting Splitting) error {
 	log.Println("Indexing folder:", folder)
 
 	folderPath := "backups/" + folder
@@ -40,47 +65,105 @@ func (op *Operations) IndexFolder(folder string) error {
 
 	for _, file_name := range files {
 		if !file_name.IsDir() {
-			sourceName := ParseSourceNameFromFileName(file_name.Name())
 			log.Println("Processing file:", file_name.Name())
-			file, err := os.Open(folderPath + "/" + file_name.Name())
+			batch, err := op.indexFile(folder, file_name.Name(), "operations index", splitting)
 			if err != nil {
-				log.Println("Failed to open file:", err)
+				log.Println("Failed to index file:", err)
 				continue
 			}
-			defer file.Close()
+			log.Printf("Inserted %d highlights from file %s into database as batch %s, skipped %d duplicates and %d malformed lines.\n",
+				batch.Inserted, file_name.Name(), batch.ID, batch.Skipped, batch.Failed)
+		}
+	}
+	return nil
+}
 
-			scanner := bufio.NewScanner(file)
-			var highlights []models.Highlight
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.TrimSpace(line) == "" {
-					continue
-				}
-				highlight := models.Highlight{
-					Source:     sourceName,
-					SourceType: folder,
-					Content:    line,
-				}
-				highlights = append(highlights, highlight)
-			}
+// indexFile imports every non-blank entry of a backup file as a highlight
+// of the source named by the file, adding the source if needed and
+// skipping entries it already has. The file is recorded as one import
+// batch attributed to importer.
+func (op *Operations) indexFile(folder, fileName, importer string, splitting Splitting) (*models.ImportBatch, error) {
+	content, err := os.ReadFile("backups/" + folder + "/" + fileName)
+	if err != nil {
+		return nil, err
+	}
 
-			// Insert highlights into the database
-			count, err := op.DB.InsertHighlights(highlights)
-			if err != nil {
-				log.Println("Failed to insert highlights into database:", err)
-				continue
-			}
-			log.Printf("Inserted %d highlights from file %s into database.\n", count, file_name.Name())
+	name, index := ParseBackupFileName(fileName)
+	sourceID, err := op.DB.SourceFor(name, folder, index)
+	if err != nil {
+		return nil, err
+	}
+	batch := &models.ImportBatch{
+		Importer:   importer,
+		Format:     "backup",
+		FileName:   fileName,
+		SourceID:   sourceID,
+		Source:     name,
+		SourceType: folder,
+	}
+	highlights, failed := ParseHighlights(splitting.Split(string(content)), splitting, batch.Source, batch.SourceType)
+	highlights, emptied := op.Normalizer.Highlights(highlights)
+	batch.Failed = len(failed) + len(emptied)
 
-			// Insert highlights into the FTS table
-			err = op.Search.InsertToFTS(highlights, sourceName)
-			if err != nil {
-				log.Println("Failed to insert highlights into search index:", err)
-				continue
-			}
-			log.Printf("Inserted %d highlights from file %s into FTS table.\n", len(highlights), file_name.Name())
+	// Insert highlights into the database
+	if err := op.DB.ImportHighlights(batch, highlights); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
 
-		}
+// UndoImport removes the highlights added by an import batch.
+func (op *Operations) UndoImport(batchID string) error {
+	count, err := UndoImport(op.DB, batchID)
+	if err != nil {
+		return err
 	}
+	log.Printf("Removed %d highlights imported in batch %s.\n", count, batchID)
 	return nil
 }
+
+// UndoImport removes the highlights added by an import batch and rewrites
+// the backup of its source, so the removed highlights are not imported
+// again from the backup by index or doctor --fix.
+func UndoImport(db *database.Db, batchID string) (int, error) {
+	batch, err := db.GetImportBatch(batchID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := db.UndoImport(batchID)
+	if err != nil {
+		return 0, err
+	}
+	err = RewriteBackup(db, batch.SourceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The source was deleted once it had no highlights left, and has
+		// no backup to rewrite
+		err = nil
+	}
+	return count, err
+}
+
+// RewriteBackup writes every highlight of a source to its backup file,
+// removing the file when the source has no highlights left. It returns
+// sql.ErrNoRows if there is no such source.
+func RewriteBackup(db *database.Db, sourceID int) error {
+	source, err := db.SourceByID(sourceID)
+	if err != nil {
+		return err
+	}
+	highlights, err := db.GetSourceHighlights(sourceID)
+	if err != nil {
+		return err
+	}
+	backupFilePath := BackupPath(source)
+	if len(highlights) == 0 {
+		if err := os.Remove(backupFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(backupFilePath), 0o755); err != nil {
+		return err
+	}
+	return WriteHighlightsToFile(highlights, backupFilePath)
+}
@@ -0,0 +1,127 @@
+// Package podcast reads podcast episodes from RSS feeds.
+package podcast
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"highlights-anki/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxFeedSize is the largest feed read, in bytes. Feeds of long-running
+// shows with full show notes run to several megabytes.
+const maxFeedSize = 32 << 20
+
+// pubDateLayouts are the RSS date formats seen in the wild; RFC 1123 is
+// the standard one.
+var pubDateLayouts = []string{
+	time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", time.RFC3339,
+}
+
+type rss struct {
+	Items []item `xml:"channel>item"`
+}
+
+type item struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	PubDate   string `xml:"pubDate"`
+	Enclosure struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+	// Matched by local name, so it is read whatever prefix the feed binds
+	// the iTunes namespace to
+	Duration string `xml:"duration"`
+}
+
+// ParseFeed reads the episodes of an RSS feed of the source sourceID, in
+// feed order. Episodes without a GUID are identified by their audio URL,
+// then by title.
+func ParseFeed(r io.Reader, sourceID int) ([]models.Episode, error) {
+	var feed rss
+	decoder := xml.NewDecoder(io.LimitReader(r, maxFeedSize))
+	// Feeds are often declared as ISO-8859-1 or windows-1252; decode those
+	// and any other charset a browser would understand into UTF-8
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset %q", charset)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	if err := decoder.Decode(&feed); err != nil {
+		return nil, fmt.Errorf("not an RSS feed: %w", err)
+	}
+
+	var episodes []models.Episode
+	for _, item := range feed.Items {
+		episode := models.Episode{
+			SourceID: sourceID,
+			GUID:     strings.TrimSpace(item.GUID),
+			Title:    strings.TrimSpace(item.Title),
+			AudioURL: strings.TrimSpace(item.Enclosure.URL),
+		}
+		// A missing or odd duration is left unknown
+		episode.Duration, _ = ParseTimestamp(item.Duration)
+		for _, layout := range pubDateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(item.PubDate)); err == nil {
+				episode.PublishedAt = t
+				break
+			}
+		}
+		for _, id := range []string{episode.AudioURL, strings.TrimSpace(item.Link), episode.Title} {
+			if episode.GUID == "" {
+				episode.GUID = id
+			}
+		}
+		if episode.GUID == "" {
+			continue
+		}
+		episodes = append(episodes, episode)
+	}
+	return episodes, nil
+}
+
+// FetchFeed downloads a feed and parses it with ParseFeed.
+func FetchFeed(ctx context.Context, client *http.Client, url string, sourceID int) ([]models.Episode, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return ParseFeed(resp.Body, sourceID)
+}
+
+// ParseTimestamp reads "34:12", "1:02:03" or a number of seconds, as used
+// both for itunes:duration and for typed timestamps, into seconds.
+func ParseTimestamp(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
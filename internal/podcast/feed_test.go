@@ -0,0 +1,36 @@
+package podcast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFeedCharsets(t *testing.T) {
+	tests := []struct {
+		name string
+		feed string
+		want string
+	}{
+		{"utf-8", `<?xml version="1.0" encoding="UTF-8"?>` + "<rss><channel><item><title>Café talk</title></item></channel></rss>", "Café talk"},
+		{"iso-8859-1", `<?xml version="1.0" encoding="ISO-8859-1"?>` + "<rss><channel><item><title>Caf\xe9 talk</title></item></channel></rss>", "Café talk"},
+		{"windows-1252", `<?xml version="1.0" encoding="windows-1252"?>` + "<rss><channel><item><title>\x93Quoted\x94 talk</title></item></channel></rss>", "“Quoted” talk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			episodes, err := ParseFeed(strings.NewReader(tt.feed), 1)
+			if err != nil {
+				t.Fatalf("ParseFeed: %v", err)
+			}
+			if len(episodes) != 1 || episodes[0].Title != tt.want {
+				t.Fatalf("got %+v, want one episode titled %q", episodes, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFeedUnknownCharset(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="x-made-up"?><rss><channel></channel></rss>`
+	if _, err := ParseFeed(strings.NewReader(feed), 1); err == nil {
+		t.Fatal("expected an error for an unknown charset")
+	}
+}
@@ -0,0 +1,353 @@
+package semantic
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Model is a latent semantic analysis (LSA) model: a truncated SVD of the
+// TF-IDF term-document matrix. Each term maps to a dense vector, and a text
+// is embedded as the TF-IDF weighted sum of its term vectors, so texts that
+// share no words but whose words co-occur elsewhere still end up close.
+type Model struct {
+	Terms       map[string]int
+	IDF         []float64
+	TermVectors [][]float32
+	Dims        int
+}
+
+const (
+	// minDocFreq drops terms seen in a single document; they cannot link
+	// documents to each other.
+	minDocFreq = 2
+	// oversample extra dimensions are computed and discarded to improve the
+	// accuracy of the randomized SVD.
+	oversample   = 10
+	powerIters   = 3
+	jacobiSweeps = 50
+)
+
+// Tokenize lowercases text and splits it into words of at least two
+// letters or digits.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if len([]rune(w)) >= 2 {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+// Train builds a model with up to dims dimensions from the given texts.
+// It returns nil when the texts share too few terms to build one.
+func Train(texts []string, dims int) *Model {
+	docFreq := map[string]int{}
+	tokenized := make([][]string, len(texts))
+	for i, text := range texts {
+		tokenized[i] = Tokenize(text)
+		seen := map[string]bool{}
+		for _, t := range tokenized[i] {
+			if !seen[t] {
+				seen[t] = true
+				docFreq[t]++
+			}
+		}
+	}
+
+	// Keep terms that link documents but are not in nearly all of them
+	var vocab []string
+	for term, df := range docFreq {
+		if df >= minDocFreq && (len(texts) < 4 || df*2 <= len(texts)) {
+			vocab = append(vocab, term)
+		}
+	}
+	sort.Strings(vocab)
+
+	model := &Model{Terms: make(map[string]int, len(vocab)), IDF: make([]float64, len(vocab))}
+	for i, term := range vocab {
+		model.Terms[term] = i
+		model.IDF[i] = math.Log(float64(len(texts)) / float64(docFreq[term]))
+	}
+
+	var docs []sparseVector
+	for _, tokens := range tokenized {
+		if v := model.weigh(tokens); len(v) > 0 {
+			docs = append(docs, v.normalized())
+		}
+	}
+
+	k := min(dims, len(vocab), len(docs)-1)
+	if k < 1 {
+		return nil
+	}
+	model.Dims = k
+	model.TermVectors = truncatedSVD(docs, len(vocab), k)
+	return model
+}
+
+// Embed returns the dense vector for text, or nil when none of its words
+// are in the model's vocabulary.
+func (m *Model) Embed(text string) []float32 {
+	weights := m.weigh(Tokenize(text))
+	if len(weights) == 0 {
+		return nil
+	}
+	vec := make([]float64, m.Dims)
+	for _, e := range weights {
+		for d, x := range m.TermVectors[e.index] {
+			vec[d] += e.value * float64(x)
+		}
+	}
+	out := make([]float32, m.Dims)
+	for d, x := range vec {
+		out[d] = float32(x)
+	}
+	return out
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 if either is zero.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+type entry struct {
+	index int
+	value float64
+}
+
+type sparseVector []entry
+
+// weigh returns the log-scaled TF-IDF weights of the known terms in tokens.
+func (m *Model) weigh(tokens []string) sparseVector {
+	counts := map[int]int{}
+	for _, t := range tokens {
+		if i, ok := m.Terms[t]; ok {
+			counts[i]++
+		}
+	}
+	v := make(sparseVector, 0, len(counts))
+	for i, tf := range counts {
+		v = append(v, entry{index: i, value: (1 + math.Log(float64(tf))) * m.IDF[i]})
+	}
+	sort.Slice(v, func(a, b int) bool { return v[a].index < v[b].index })
+	return v
+}
+
+func (v sparseVector) normalized() sparseVector {
+	var norm float64
+	for _, e := range v {
+		norm += e.value * e.value
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return v
+	}
+	for i := range v {
+		v[i].value /= norm
+	}
+	return v
+}
+
+// truncatedSVD returns the top k left singular vectors of the terms x docs
+// matrix whose columns are docs, as one row of length k per term. It uses
+// randomized subspace iteration, so only a terms x (k+oversample) dense
+// matrix is ever held in memory.
+func truncatedSVD(docs []sparseVector, terms, k int) [][]float32 {
+	l := min(k+oversample, terms, len(docs))
+	rng := rand.New(rand.NewSource(1))
+
+	omega := newMatrix(len(docs), l)
+	for i := range omega {
+		for j := range omega[i] {
+			omega[i][j] = rng.NormFloat64()
+		}
+	}
+
+	q := multiply(docs, terms, omega)
+	orthonormalize(q)
+	for range powerIters {
+		z := multiplyTransposed(docs, q)
+		orthonormalize(z)
+		q = multiply(docs, terms, z)
+		orthonormalize(q)
+	}
+
+	// B = Q^T A is small (l x docs); the eigenvectors of B B^T rotate Q
+	// into the left singular vectors of A.
+	bt := multiplyTransposed(docs, q)
+	gram := newMatrix(l, l)
+	for _, row := range bt {
+		for i := range l {
+			if row[i] == 0 {
+				continue
+			}
+			for j := range l {
+				gram[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	eigenvalues, eigenvectors := jacobiEigen(gram)
+
+	order := make([]int, l)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return eigenvalues[order[a]] > eigenvalues[order[b]] })
+
+	u := make([][]float32, terms)
+	for t := range terms {
+		u[t] = make([]float32, k)
+		for d := range k {
+			var sum float64
+			col := order[d]
+			for j := range l {
+				sum += q[t][j] * eigenvectors[j][col]
+			}
+			u[t][d] = float32(sum)
+		}
+	}
+	return u
+}
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = make([]float64, cols)
+	}
+	return m
+}
+
+// multiply computes A X for the sparse terms x docs matrix A and a dense
+// docs x l matrix X.
+func multiply(docs []sparseVector, terms int, x [][]float64) [][]float64 {
+	l := len(x[0])
+	y := newMatrix(terms, l)
+	for j, doc := range docs {
+		for _, e := range doc {
+			row := y[e.index]
+			for c := range l {
+				row[c] += e.value * x[j][c]
+			}
+		}
+	}
+	return y
+}
+
+// multiplyTransposed computes A^T Y for a dense terms x l matrix Y.
+func multiplyTransposed(docs []sparseVector, y [][]float64) [][]float64 {
+	l := len(y[0])
+	z := newMatrix(len(docs), l)
+	for j, doc := range docs {
+		for _, e := range doc {
+			for c := range l {
+				z[j][c] += e.value * y[e.index][c]
+			}
+		}
+	}
+	return z
+}
+
+// orthonormalize replaces the columns of m with an orthonormal basis of
+// their span using modified Gram-Schmidt. Dependent columns become zero.
+func orthonormalize(m [][]float64) {
+	cols := len(m[0])
+	for c := range cols {
+		for p := range c {
+			var dot float64
+			for _, row := range m {
+				dot += row[c] * row[p]
+			}
+			for _, row := range m {
+				row[c] -= dot * row[p]
+			}
+		}
+		var norm float64
+		for _, row := range m {
+			norm += row[c] * row[c]
+		}
+		norm = math.Sqrt(norm)
+		for _, row := range m {
+			if norm < 1e-10 {
+				row[c] = 0
+			} else {
+				row[c] /= norm
+			}
+		}
+	}
+}
+
+// jacobiEigen diagonalizes the symmetric matrix a in place with cyclic
+// Jacobi rotations and returns its eigenvalues and eigenvectors (as
+// columns).
+func jacobiEigen(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+	v := newMatrix(n, n)
+	for i := range n {
+		v[i][i] = 1
+	}
+
+	for range jacobiSweeps {
+		var off float64
+		for i := range n {
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+
+		for p := range n {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(a[p][q]) < 1e-15 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := range n {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := range n {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := range n {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := range n {
+		values[i] = a[i][i]
+	}
+	return values, v
+}
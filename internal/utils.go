@@ -3,14 +3,263 @@ package internal
 import (
 	"bufio"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"highlights-anki/internal/models"
+	"highlights-anki/internal/podcast"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// highlightedAtLayouts are the date formats accepted before a tab at the
+// start of an imported line, in local time.
+var highlightedAtLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339}
+
+// Ways of splitting imported text into highlights.
+const (
+	// SplitLines makes each line a highlight.
+	SplitLines = "lines"
+	// SplitBlocks makes each run of lines between blank lines a highlight.
+	SplitBlocks = "blocks"
+	// SplitDelimiter makes each run of lines between lines holding only a
+	// delimiter, such as "---", a highlight.
+	SplitDelimiter = "delimiter"
+)
+
+// lineSeparator stands for the line breaks within a highlight in backups,
+// which hold one highlight per line.
+const lineSeparator = "\u2028"
+
+// Splitting says how imported text is divided into highlights: Mode is one
+// of SplitLines, SplitBlocks and SplitDelimiter, and Delimiter is the
+// separator line for SplitDelimiter.
+type Splitting struct {
+	Mode      string
+	Delimiter string
+}
+
+// NewSplitting checks a splitting mode and delimiter given by the user. An
+// empty mode splits lines.
+func NewSplitting(mode, delimiter string) (Splitting, error) {
+	delimiter = strings.TrimSpace(delimiter)
+	switch mode {
+	case "", SplitLines:
+		return Splitting{Mode: SplitLines}, nil
+	case SplitBlocks:
+		return Splitting{Mode: SplitBlocks}, nil
+	case SplitDelimiter:
+		if delimiter == "" {
+			return Splitting{}, errors.New("a delimiter is required to split on one")
+		}
+		return Splitting{Mode: SplitDelimiter, Delimiter: delimiter}, nil
+	}
+	return Splitting{}, fmt.Errorf("unknown splitting mode %q", mode)
+}
+
+// Split divides text into the entries ParseHighlights reads, keeping the
+// line breaks within each entry.
+func (s Splitting) Split(text string) []string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if s.splitsLines() {
+		return lines
+	}
+
+	var entries []string
+	var entry []string
+	flush := func() {
+		if len(entry) > 0 {
+			entries = append(entries, strings.Join(entry, "\n"))
+			entry = nil
+		}
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if (s.Mode == SplitBlocks && trimmed == "") || (s.Mode == SplitDelimiter && trimmed == s.Delimiter) {
+			flush()
+			continue
+		}
+		entry = append(entry, strings.TrimRight(line, " \t"))
+	}
+	flush()
+	return entries
+}
+
+// splitsLines reports whether s makes each line an entry, as the empty
+// Splitting does.
+func (s Splitting) splitsLines() bool {
+	return s.Mode != SplitBlocks && s.Mode != SplitDelimiter
+}
+
+// ParseHighlightLine splits an imported line into the highlight text and
+// the time it was highlighted. A line may start with a date and a tab, as
+// in "2024-03-05\tText"; otherwise the whole line is the text and the time
+// is zero.
+func ParseHighlightLine(line string) (string, time.Time) {
+	prefix, rest, found := strings.Cut(strings.TrimLeft(line, " "), "\t")
+	line = strings.TrimSpace(line)
+	if !found {
+		return line, time.Time{}
+	}
+	for _, layout := range highlightedAtLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(prefix), time.Local); err == nil {
+			return strings.TrimSpace(rest), t
+		}
+	}
+	return line, time.Time{}
+}
+
+// positionPattern matches the position a highlight's text may start with,
+// as in "[p. 42] Text": a location, a page, a percentage or a timestamp.
+var positionPattern = regexp.MustCompile(`(?i)^\[\s*(?:(loc|location|p|page)\.?\s*(\d+)|(\d{1,3})\s*%|(\d+(?::\d{2}){1,2}))\s*\]\s*`)
+
+// labelPattern matches a color or kind a highlight's text may start with,
+// as in "[yellow] Text" or "[note] Text".
+var labelPattern = regexp.MustCompile(`^\[\s*([A-Za-z]+)\s*\]\s*`)
+
+// parsePrefixes moves the position, color and kind at the start of
+// h.Content, in any order, into h.
+func parsePrefixes(h *models.Highlight) {
+	for parsePosition(h) || parseLabel(h) {
+	}
+}
+
+// parseLabel moves the color or kind at the start of h.Content, if any,
+// into h, and reports whether there was one.
+func parseLabel(h *models.Highlight) bool {
+	m := labelPattern.FindStringSubmatch(h.Content)
+	if m == nil {
+		return false
+	}
+	label := strings.ToLower(m[1])
+	switch {
+	case h.Color == "" && slices.Contains(models.HighlightColors, label):
+		h.Color = label
+	case h.Kind == "" && slices.Contains(models.HighlightKinds, label):
+		if label != "quote" {
+			h.Kind = label
+		}
+	default:
+		return false
+	}
+	h.Content = strings.TrimSpace(h.Content[len(m[0]):])
+	return true
+}
+
+// parsePosition moves the position at the start of h.Content, if any, into
+// the matching field of h, and reports whether there was one.
+func parsePosition(h *models.Highlight) bool {
+	m := positionPattern.FindStringSubmatch(h.Content)
+	if m == nil {
+		return false
+	}
+	switch {
+	case m[2] != "":
+		n, _ := strconv.Atoi(m[2])
+		if strings.HasPrefix(strings.ToLower(m[1]), "p") {
+			h.Page = n
+		} else {
+			h.Location = n
+		}
+	case m[3] != "":
+		h.Percent, _ = strconv.Atoi(m[3])
+	default:
+		h.Timestamp, _ = podcast.ParseTimestamp(m[4])
+	}
+	h.Content = strings.TrimSpace(h.Content[len(m[0]):])
+	return true
+}
+
+// chapterHeading reports whether line is a heading such as "# Chapter 3",
+// which starts the chapter of the lines after it, and returns the chapter.
+// A bare "#" ends the current chapter. A highlight of several lines is
+// never a heading, even when it starts with "# ".
+func chapterHeading(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.ContainsAny(line, "\n"+lineSeparator) {
+		return "", false
+	}
+	title := strings.TrimLeft(line, "#")
+	if title == line || (title != "" && title[0] != ' ') {
+		return "", false
+	}
+	return strings.TrimSpace(title), true
+}
+
+// ParseHighlights turns entries made by splitting.Split into highlights of
+// one source, in the order they were given. When splitting lines, heading
+// lines set the chapter of the entries that follow; other modes keep every
+// entry as a highlight. An entry's text may start with its positions,
+// color and kind, as in "2024-03-05\t[p. 42] [yellow] Text". Blank entries
+// are ignored; entries that have a date or position but no text are
+// returned as failed.
+func ParseHighlights(entries []string, splitting Splitting, source, sourceType string) ([]models.Highlight, []string) {
+	var highlights []models.Highlight
+	var failed []string
+	chapter := ""
+	for _, line := range entries {
+		if splitting.splitsLines() {
+			if title, ok := chapterHeading(line); ok {
+				chapter = title
+				continue
+			}
+		}
+		line = strings.TrimSpace(strings.ReplaceAll(line, lineSeparator, "\n"))
+		if line == "" {
+			continue
+		}
+		content, highlightedAt := ParseHighlightLine(line)
+		highlight := models.Highlight{
+			Source:        source,
+			SourceType:    sourceType,
+			Content:       content,
+			HighlightedAt: highlightedAt,
+			Chapter:       chapter,
+		}
+		parsePrefixes(&highlight)
+		if highlight.Content == "" {
+			failed = append(failed, strings.TrimSpace(line))
+			continue
+		}
+		highlights = append(highlights, highlight)
+	}
+	return highlights, failed
+}
+
+// FormatHighlightLine is the inverse of ParseHighlightLine and of the
+// positions, color and kind ParseHighlights reads, used for backups. It
+// writes the text as imported, before normalization, and line breaks
+// within the highlight as U+2028 LINE SEPARATOR to keep it on one line.
+func FormatHighlightLine(h models.Highlight) string {
+	var prefix string
+	for _, label := range append(h.PositionLabels(), h.Color, h.Kind) {
+		if label != "" {
+			prefix += "[" + label + "] "
+		}
+	}
+	content := prefix + strings.ReplaceAll(h.Original(), "\n", lineSeparator)
+	if h.HighlightedAt.IsZero() {
+		return content
+	}
+	t := h.HighlightedAt.Local()
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02") + "\t" + content
+	}
+	return t.Format("2006-01-02 15:04:05") + "\t" + content
+}
+
+// WriteHighlightsToFile writes highlights to the backup file at filePath,
+// creating its folder when the source type has none yet.
 func WriteHighlightsToFile(highlights []models.Highlight, filePath string) error {
 	// Always persist highlights to a file for backup
 	fmt.Println("Writing highlights to file:", filePath)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
 	file, err := os.Create(filePath)
 
 	if err != nil {
@@ -21,8 +270,15 @@ func WriteHighlightsToFile(highlights []models.Highlight, filePath string) error
 	// Use a buffered writer for efficiency
 	writer := bufio.NewWriter(file)
 
+	chapter := ""
 	for _, h := range highlights {
-		_, err := writer.WriteString(h.Content + "\n")
+		if h.Chapter != chapter {
+			chapter = h.Chapter
+			if _, err := writer.WriteString(strings.TrimSpace("# "+chapter) + "\n"); err != nil {
+				return err
+			}
+		}
+		_, err := writer.WriteString(FormatHighlightLine(h) + "\n")
 		if err != nil {
 			fmt.Println("Error writing highlight to file:", err)
 			return err
@@ -66,3 +322,30 @@ func ParseSourceNameFromFileName(fileName string) string {
 
 	return fileName[:underscoreIndex]
 }
+
+// backupIndexPattern matches the " [2]" a backup file name gives after the
+// name of the second and later sources of the same name and type.
+var backupIndexPattern = regexp.MustCompile(`^(.*) \[(\d+)\]$`)
+
+// BackupPath is the backup file of a source,
+// backups/<type>/<name>_highlights.txt, with " [n]" after the name for the
+// n-th source of that name and type.
+func BackupPath(source models.Source) string {
+	name := source.Name
+	if source.NameIndex > 1 {
+		name += fmt.Sprintf(" [%d]", source.NameIndex)
+	}
+	return fmt.Sprintf("backups/%s/%s_highlights.txt", source.Type, name)
+}
+
+// ParseBackupFileName returns the name and the NameIndex of the source a
+// backup file written at BackupPath is of.
+func ParseBackupFileName(fileName string) (string, int) {
+	name := ParseSourceNameFromFileName(fileName)
+	if match := backupIndexPattern.FindStringSubmatch(name); match != nil {
+		if index, err := strconv.Atoi(match[2]); err == nil && index > 0 {
+			return match[1], index
+		}
+	}
+	return name, 1
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"highlights-anki/internal/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupPath(t *testing.T) {
+	tests := []struct {
+		source models.Source
+		path   string
+	}{
+		{models.Source{Name: "Walden", Type: "book", NameIndex: 1}, "backups/book/Walden_highlights.txt"},
+		{models.Source{Name: "Walden", Type: "book", NameIndex: 3}, "backups/book/Walden [3]_highlights.txt"},
+		{models.Source{Name: "Huberman Lab", Type: "podcast", NameIndex: 2}, "backups/podcast/Huberman Lab [2]_highlights.txt"},
+	}
+	for _, tt := range tests {
+		path := BackupPath(tt.source)
+		if path != tt.path {
+			t.Errorf("BackupPath(%+v) = %q, want %q", tt.source, path, tt.path)
+		}
+		name, index := ParseBackupFileName(filepath.Base(path))
+		if name != tt.source.Name || index != tt.source.NameIndex {
+			t.Errorf("ParseBackupFileName(%q) = %q, %d; want %q, %d", path, name, index, tt.source.Name, tt.source.NameIndex)
+		}
+	}
+}
+
+func TestNewSplitting(t *testing.T) {
+	tests := []struct {
+		mode, delimiter string
+		want            Splitting
+		wantErr         bool
+	}{
+		{"", "", Splitting{Mode: SplitLines}, false},
+		{SplitLines, "---", Splitting{Mode: SplitLines}, false},
+		{SplitBlocks, "", Splitting{Mode: SplitBlocks}, false},
+		{SplitDelimiter, " --- ", Splitting{Mode: SplitDelimiter, Delimiter: "---"}, false},
+		{SplitDelimiter, "  ", Splitting{}, true},
+		{"paragraphs", "", Splitting{}, true},
+	}
+	for _, tt := range tests {
+		got, err := NewSplitting(tt.mode, tt.delimiter)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NewSplitting(%q, %q) = %+v, %v; want %+v, error %t", tt.mode, tt.delimiter, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	text := "First line\r\nstill first  \n\n\nSecond\n---\nThird\n  ---  \n"
+	tests := []struct {
+		splitting Splitting
+		want      []string
+	}{
+		{Splitting{Mode: SplitLines}, []string{"First line", "still first  ", "", "", "Second", "---", "Third", "  ---  ", ""}},
+		{Splitting{Mode: SplitBlocks}, []string{"First line\nstill first", "Second\n---\nThird\n  ---"}},
+		{Splitting{Mode: SplitDelimiter, Delimiter: "---"}, []string{"First line\nstill first\n\n\nSecond", "Third", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.splitting.Mode, func(t *testing.T) {
+			if got := tt.splitting.Split(text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHighlights(t *testing.T) {
+	entries := []string{
+		"# Chapter 1",
+		"2024-03-05\t[p. 42] [yellow] Habits compound.",
+		"",
+		"[note] [loc. 1234] Try this\u2028tomorrow.",
+		"#",
+		"[12%] Done.",
+		"2024-03-05 09:30\t[p. 7]",
+	}
+	highlights, failed := ParseHighlights(entries, Splitting{Mode: SplitLines}, "Atomic Habits", "book")
+	want := []models.Highlight{
+		{Content: "Habits compound.", HighlightedAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local), Chapter: "Chapter 1", Page: 42, Color: "yellow"},
+		{Content: "Try this\ntomorrow.", Chapter: "Chapter 1", Location: 1234, Kind: "note"},
+		{Content: "Done.", Percent: 12},
+	}
+	for i := range want {
+		want[i].Source, want[i].SourceType = "Atomic Habits", "book"
+	}
+	if !reflect.DeepEqual(highlights, want) {
+		t.Errorf("got %+v\nwant %+v", highlights, want)
+	}
+	if !reflect.DeepEqual(failed, []string{"2024-03-05 09:30\t[p. 7]"}) {
+		t.Errorf("failed = %q, want the entry without text", failed)
+	}
+}
+
+func TestParseHighlightsKeepsHeadingsOfSeveralLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		splitting Splitting
+		text      string
+	}{
+		{"blocks", Splitting{Mode: SplitBlocks}, "# Notes\nRead daily.\n\n# Chapter 2"},
+		{"delimiter", Splitting{Mode: SplitDelimiter, Delimiter: "---"}, "# Notes\nRead daily.\n---\n# Chapter 2"},
+		{"backup line", Splitting{Mode: SplitLines}, "# Notes\u2028Read daily.\n# Chapter 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			highlights, failed := ParseHighlights(tt.splitting.Split(tt.text), tt.splitting, "", "")
+			var got []string
+			for _, highlight := range highlights {
+				got = append(got, highlight.Chapter+"|"+highlight.Content)
+			}
+			want := []string{"|# Notes\nRead daily."}
+			if tt.splitting.Mode != SplitLines {
+				// Outside line mode a heading alone is a highlight too
+				want = append(want, "|# Chapter 2")
+			}
+			if len(failed) > 0 || !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, %q; want %q", got, failed, want)
+			}
+		})
+	}
+}
+
+func TestFormatHighlightLineRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		highlight models.Highlight
+		line      string
+	}{
+		{"text only", models.Highlight{Content: "Habits compound."}, "Habits compound."},
+		{"date", models.Highlight{Content: "Habits compound.", HighlightedAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)},
+			"2024-03-05\tHabits compound."},
+		{"date and time", models.Highlight{Content: "Habits compound.", HighlightedAt: time.Date(2024, 3, 5, 9, 30, 15, 0, time.Local)},
+			"2024-03-05 09:30:15\tHabits compound."},
+		{"positions", models.Highlight{Content: "Habits compound.", Location: 1234, Page: 42, Percent: 12},
+			"[loc. 1234] [p. 42] [12%] Habits compound."},
+		{"timestamp", models.Highlight{Content: "Listen.", Timestamp: 3723}, "[1:02:03] Listen."},
+		{"color and kind", models.Highlight{Content: "Try this.", Color: "blue", Kind: "note"}, "[blue] [note] Try this."},
+		{"several lines", models.Highlight{Content: "First\nsecond"}, "First\u2028second"},
+		{"heading of several lines", models.Highlight{Content: "# Tip\nRead daily."}, "# Tip\u2028Read daily."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := FormatHighlightLine(tt.highlight)
+			if line != tt.line {
+				t.Errorf("FormatHighlightLine = %q, want %q", line, tt.line)
+			}
+			highlights, failed := ParseHighlights([]string{line}, Splitting{}, "", "")
+			if len(failed) > 0 || len(highlights) != 1 || !reflect.DeepEqual(highlights[0], tt.highlight) {
+				t.Errorf("ParseHighlights(%q) = %+v, %q; want %+v", line, highlights, failed, tt.highlight)
+			}
+		})
+	}
+}
+
+func TestWriteHighlightsToFileRoundTrip(t *testing.T) {
+	highlights := []models.Highlight{
+		{Source: "Atomic Habits", SourceType: "book", Content: "Before any chapter."},
+		{Source: "Atomic Habits", SourceType: "book", Content: "Habits compound.", Chapter: "Chapter 1", Page: 42},
+		{Source: "Atomic Habits", SourceType: "book", Content: "Systems\nover goals.", Chapter: "Chapter 1", Color: "green"},
+		{Source: "Atomic Habits", SourceType: "book", Content: "Identity first.", Chapter: "Chapter 2"},
+		{Source: "Atomic Habits", SourceType: "book", Content: "No chapter again."},
+	}
+	path := filepath.Join(t.TempDir(), "book", "Atomic Habits_highlights.txt")
+	if err := WriteHighlightsToFile(highlights, path); err != nil {
+		t.Fatalf("WriteHighlightsToFile: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 8 {
+		t.Errorf("wrote %d lines, want 5 highlights and 3 headings:\n%s", lines, data)
+	}
+
+	var splitting Splitting
+	got, failed := ParseHighlights(splitting.Split(string(data)), splitting, "Atomic Habits", "book")
+	if len(failed) > 0 || !reflect.DeepEqual(got, highlights) {
+		t.Errorf("read back %+v, %q\nwant %+v", got, failed, highlights)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"highlights-anki/internal"
 	"highlights-anki/internal/database"
 	"log"
 	"os"
+	"strconv"
 )
 
 func main() {
@@ -42,14 +44,112 @@ func main() {
 		}
 
 		if os.Args[1] == "index" {
+			if len(os.Args) < 3 {
+				log.Fatal("Usage: operations index <folder> [lines | blocks | delimiter <delimiter>]")
+			}
 			folder := os.Args[2]
-			err := op.IndexFolder(folder)
+			mode, delimiter := "", ""
+			if len(os.Args) > 3 {
+				mode = os.Args[3]
+			}
+			if len(os.Args) > 4 {
+				delimiter = os.Args[4]
+			}
+			splitting, err := internal.NewSplitting(mode, delimiter)
+			if err != nil {
+				log.Fatal("Invalid splitting:", err)
+			}
+			err = op.IndexFolder(folder, splitting)
 			if err != nil {
 				log.Fatal("Failed to index folder:", err)
 			}
 			log.Println("Indexed folder:", folder)
 			return
 		}
+
+		if os.Args[1] == "reindex" {
+			err := op.Reindex()
+			if err != nil {
+				log.Fatal("Failed to rebuild search indexes:", err)
+			}
+			log.Println("Rebuilt search indexes")
+			return
+		}
+
+		if os.Args[1] == "imports" {
+			batches, err := db.GetImportBatches(50)
+			if err != nil {
+				log.Fatal("Failed to list imports:", err)
+			}
+			for _, batch := range batches {
+				status := ""
+				if !batch.UndoneAt.IsZero() {
+					status = " (undone)"
+				}
+				fmt.Printf("%s  %s  %-20s %s: %d inserted, %d skipped, %d failed%s\n",
+					batch.ID, batch.CreatedAt.Local().Format("2006-01-02 15:04"), batch.Importer, batch.Source,
+					batch.Inserted, batch.Skipped, batch.Failed, status)
+			}
+			return
+		}
+
+		if os.Args[1] == "undo" {
+			if len(os.Args) < 3 {
+				log.Fatal("Usage: operations undo <batch id>")
+			}
+			err := op.UndoImport(os.Args[2])
+			if err != nil {
+				log.Fatal("Failed to undo import:", err)
+			}
+			return
+		}
+
+		if os.Args[1] == "doctor" {
+			fix := len(os.Args) > 2 && os.Args[2] == "--fix"
+			checks, err := op.Doctor(fix)
+			if err != nil {
+				log.Fatal("Failed to run doctor:", err)
+			}
+			unresolved := 0
+			for _, check := range checks {
+				switch {
+				case len(check.Problems) == 0:
+					fmt.Printf("ok      %s\n", check.Name)
+				case check.Fixed:
+					fmt.Printf("fixed   %s\n", check.Name)
+				default:
+					fmt.Printf("FAILED  %s\n", check.Name)
+					unresolved++
+				}
+				for _, problem := range check.Problems {
+					fmt.Println("        -", problem)
+				}
+			}
+			if unresolved > 0 {
+				if !fix {
+					fmt.Println("Run `go run ./operations doctor --fix` to repair what can be repaired.")
+				}
+				os.Exit(1)
+			}
+			return
+		}
+
+		if os.Args[1] == "embed" {
+			dims := database.DefaultSemanticDims
+			if len(os.Args) > 2 {
+				n, err := strconv.Atoi(os.Args[2])
+				if err != nil {
+					log.Fatal("Invalid number of dimensions:", os.Args[2])
+				}
+				dims = n
+			}
+			err := op.BuildSemanticIndex(dims)
+			if err != nil {
+				log.Fatal("Failed to build semantic index:", err)
+			}
+			log.Println("Built semantic index")
+			return
+		}
 	}
 
 	// folderToIndex := "podcast"
@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"highlights-anki/internal/database"
 	"highlights-anki/internal/handlers"
+	"highlights-anki/internal/metadata"
+	"highlights-anki/internal/normalize"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"text/template"
 	"time"
@@ -79,13 +82,53 @@ func main() {
 	defer search_db.Close()
 
 	h := handlers.NewHandlers(db, search_db)
+	// Point these at a local stub to try metadata lookups offline
+	h.Metadata = metadata.NewOpenLibrary(os.Getenv("OPENLIBRARY_URL"), os.Getenv("OPENLIBRARY_COVERS_URL"))
+	normalizer, normalize_err := normalize.Parse(os.Getenv("HIGHLIGHTS_NORMALIZE"))
+	if normalize_err != nil {
+		log.Fatal("Invalid HIGHLIGHTS_NORMALIZE:", normalize_err)
+	}
+	h.Normalizer = normalizer
 
 	http.HandleFunc("/admin/upload", loggingMiddleware(h.AddHighlights))
+	http.HandleFunc("/admin/imports", loggingMiddleware(h.ImportsHandler))
+	http.HandleFunc("/admin/imports/{id}", loggingMiddleware(h.ImportHighlightsHandler))
+	http.HandleFunc("/admin/imports/{id}/undo", loggingMiddleware(h.UndoImportHandler))
+	http.HandleFunc("/admin/duplicates", loggingMiddleware(h.DuplicatesHandler))
+	http.HandleFunc("/admin/duplicates/merge", loggingMiddleware(h.MergeDuplicatesHandler))
+	http.HandleFunc("/admin/duplicates/dismiss", loggingMiddleware(h.DismissDuplicatesHandler))
+	http.HandleFunc("/admin/metadata", loggingMiddleware(h.MetadataHandler))
+	http.HandleFunc("/admin/metadata/lookup", loggingMiddleware(h.MetadataLookupHandler))
+	http.HandleFunc("/admin/metadata/apply", loggingMiddleware(h.MetadataApplyHandler))
+	http.HandleFunc("/admin/source-types", loggingMiddleware(h.SourceTypesHandler))
+	http.HandleFunc("/admin/source-types/delete", loggingMiddleware(h.DeleteSourceTypeHandler))
+	http.HandleFunc("/source-types/options", loggingMiddleware(h.SourceTypeOptionsHandler))
 	http.HandleFunc("/random", loggingMiddleware(h.GetRandomHighlights))
 	http.HandleFunc("/sources", loggingMiddleware(h.SourcesHandler))
-	http.HandleFunc("/source/", loggingMiddleware(h.SourceHighlightsHandler))
+	http.HandleFunc("/sources/{id}", loggingMiddleware(h.SourceHighlightsHandler))
+	http.HandleFunc("/source/{name}", loggingMiddleware(h.SourceNameRedirectHandler))
 	http.HandleFunc("/search", loggingMiddleware(h.SearchHandler))
 	http.HandleFunc("/searchResults", loggingMiddleware(h.SearchResultsHandler))
+	http.HandleFunc("/searchSuggest", loggingMiddleware(h.SearchSuggestHandler))
+	http.HandleFunc("/api/search", loggingMiddleware(h.APISearchHandler))
+	http.HandleFunc("/api/suggest", loggingMiddleware(h.APISuggestHandler))
+	http.HandleFunc("/api/export", loggingMiddleware(h.APIExportHandler))
+	http.HandleFunc("/highlights/{id}/card.png", loggingMiddleware(h.HighlightCardHandler))
+	http.HandleFunc("/highlights/{id}/related", loggingMiddleware(h.RelatedHighlightsHandler))
+	http.HandleFunc("/highlights/{id}/tags", loggingMiddleware(h.HighlightTagsHandler))
+	http.HandleFunc("/highlights/{id}/note", loggingMiddleware(h.HighlightNoteHandler))
+	http.HandleFunc("/highlights/{id}/episode", loggingMiddleware(h.HighlightEpisodeHandler))
+	http.HandleFunc("/highlights/{id}/color", loggingMiddleware(h.HighlightColorHandler))
+	http.HandleFunc("/highlights/{id}/flag", loggingMiddleware(h.HighlightFlagHandler))
+	http.HandleFunc("/colors", loggingMiddleware(h.ColorFiltersHandler))
+	http.HandleFunc("/favorites", loggingMiddleware(h.FavoritesHandler))
+	http.HandleFunc("/sources/tags", loggingMiddleware(h.SourceTagsHandler))
+	http.HandleFunc("/sources/metadata", loggingMiddleware(h.SourceMetadataHandler))
+	http.HandleFunc("/sources/episodes", loggingMiddleware(h.SourceEpisodesHandler))
+	http.HandleFunc("/covers/{file}", loggingMiddleware(h.CoverHandler))
+	http.HandleFunc("/tags", loggingMiddleware(h.TagsHandler))
+	http.HandleFunc("/authors", loggingMiddleware(h.AuthorsHandler))
+	http.HandleFunc("/authors/{id}", loggingMiddleware(h.AuthorHandler))
 
 	// if err := initDb(); err != nil {
 	// 	log.Fatal(err)
@@ -95,7 +138,7 @@ func main() {
 
 	// Load templates from files
 	var err error
-	tmpl, err = template.ParseGlob("templates/*.html")
+	tmpl, err = template.New("").Funcs(handlers.TemplateFuncs(h.DB)).ParseGlob("templates/*.html")
 	if err != nil {
 		log.Fatal("Failed to parse templates:", err)
 	}